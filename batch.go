@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Statuses of one receipt in a batch.
+const (
+	batchAccepted  = "accepted"
+	batchInvalid   = "invalid"
+	batchDuplicate = "duplicate"
+	batchQueueFull = "queue_full"
+)
+
+// maxBatchReceipts is the most receipts accepted in one batch.
+var maxBatchReceipts = defaultBatch.MaxReceipts
+
+var errBatchTooLarge = errors.New("too many receipts in the batch")
+
+// processBatch validates and processes every receipt in a JSON array or NDJSON body on its own,
+// so one bad receipt doesn't fail the rest.
+func processBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeError(w, http.StatusBadRequest, "The batch is invalid.")
+		return
+	}
+	defer r.Body.Close()
+
+	items, err := splitBatch(r.Body, r.Header.Get("Content-Type"))
+	if errors.Is(err, errBatchTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("A batch can contain at most %d receipts.", maxBatchReceipts))
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		writeError(w, http.StatusBadRequest, "The batch is invalid.")
+		return
+	}
+
+	batchResponse := &pb.BatchResponse{Results: []*pb.BatchResult{}}
+	for i, item := range items {
+		result := processBatchItem(r, item)
+		result.Index = int32(i)
+		if result.Status == batchAccepted {
+			batchResponse.Accepted++
+		} else {
+			batchResponse.Rejected++
+		}
+		batchResponse.Results = append(batchResponse.Results, result)
+	}
+
+	response, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(batchResponse)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func processBatchItem(r *http.Request, item []byte) *pb.BatchResult {
+	receiptJSON, userID, errs := decodeSubmission(item, r.Header.Get("X-User-Id"))
+	if len(errs) > 0 {
+		return &pb.BatchResult{Status: batchInvalid, Errors: toFieldErrors(errs)}
+	}
+
+	receipt, errs, warnings := receiptprocessor.ValidateReceiptJSON(receiptJSON)
+	if len(errs) > 0 {
+		return &pb.BatchResult{Status: batchInvalid, Errors: toFieldErrors(errs)}
+	}
+
+	// a batch waits for room in the queue rather than turning receipts away
+	id, err := receiptprocessor.ProcessReceiptWait(r.Context(), userID, receipt)
+	result := &pb.BatchResult{Id: id, Status: batchAccepted, Warnings: toFieldErrors(warnings)}
+	switch {
+	case errors.Is(err, receiptprocessor.ErrDuplicateReceipt):
+		result.Status = batchDuplicate
+	case errors.Is(err, receiptprocessor.ErrQueueFull):
+		result.Status = batchQueueFull
+	case err != nil:
+		log.Print(err)
+		result.Status = batchInvalid
+	}
+	return result
+}
+
+// splitBatch reads the receipts of a batch without decoding them, from a JSON array or, when the
+// content type says so or the body doesn't start with '[', one receipt per line.
+func splitBatch(body io.Reader, contentType string) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' && !strings.HasPrefix(contentType, "application/x-ndjson") {
+		return splitArray(reader)
+	}
+	return splitLines(reader)
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, fmt.Errorf("reading batch: %w", err)
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		reader.Discard(1)
+	}
+}
+
+func splitArray(reader io.Reader) ([]json.RawMessage, error) {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("reading batch: %w", err)
+	}
+	items := []json.RawMessage{}
+	for decoder.More() {
+		if len(items) == maxBatchReceipts {
+			return nil, errBatchTooLarge
+		}
+		item := json.RawMessage{}
+		if err := decoder.Decode(&item); err != nil {
+			return nil, fmt.Errorf("reading receipt %d of batch: %w", len(items), err)
+		}
+		items = append(items, item)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("reading batch: %w", err)
+	}
+	return items, nil
+}
+
+// splitLines reads NDJSON. A line that isn't valid JSON only fails its own receipt.
+func splitLines(reader *bufio.Reader) ([]json.RawMessage, error) {
+	items := []json.RawMessage{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if len(items) == maxBatchReceipts {
+				return nil, errBatchTooLarge
+			}
+			items = append(items, json.RawMessage(line))
+		}
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading batch: %w", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func postBatch(t *testing.T, body string, contentType string) (*httptest.ResponseRecorder, *pb.BatchResponse) {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/receipts/batch", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	buildRouter().ServeHTTP(rec, req)
+
+	batchResponse := &pb.BatchResponse{}
+	if rec.Code == http.StatusOK {
+		if err := protojson.Unmarshal(rec.Body.Bytes(), batchResponse); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, batchResponse
+}
+
+func TestProcessBatch(t *testing.T) {
+	valid := func(retailer string) string {
+		return `{"retailer":"` + retailer + `","purchaseDate":"2022-07-01","purchaseTime":"09:00","items":[{"shortDescription":"Milk","price":"3.49"}],"total":"3.49"}`
+	}
+
+	t.Run("JSONArray", func(t *testing.T) {
+		body := "[" + valid("Batch Grocer A") + `, {"retailer":"","purchaseDate":"2022-07-01"}, ` + valid("Batch Grocer B") + "]"
+		rec, response := postBatch(t, body, "application/json")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d", rec.Code)
+		}
+		if response.Accepted != 2 || response.Rejected != 1 || len(response.Results) != 3 {
+			t.Fatalf("expected 2 accepted and 1 rejected, got %v", response)
+		}
+		if response.Results[0].Id == "" || response.Results[0].Status != "accepted" {
+			t.Errorf("expected the first receipt to be accepted, got %v", response.Results[0])
+		}
+		var raw struct {
+			Results []map[string]any `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &raw); err != nil || raw.Results[0]["index"] != float64(0) {
+			t.Errorf("expected the first result to include its index, got %s", rec.Body.String())
+		}
+		invalid := response.Results[1]
+		if invalid.Index != 1 || invalid.Status != "invalid" || invalid.Id != "" || len(invalid.Errors) == 0 {
+			t.Errorf("expected the second receipt to be invalid with field errors, got %v", invalid)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		body := valid("Batch Grocer C") + "\n\nnot json\n" + valid("Batch Grocer D")
+		rec, response := postBatch(t, body, "application/x-ndjson")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d", rec.Code)
+		}
+		if response.Accepted != 2 || len(response.Results) != 3 || response.Results[1].Errors[0].Code != "malformed" {
+			t.Errorf("expected the malformed line to fail on its own, got %v", response)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		defer func(max int) { maxBatchReceipts = max }(maxBatchReceipts)
+		maxBatchReceipts = 1
+		rec, _ := postBatch(t, "["+valid("Batch Grocer E")+","+valid("Batch Grocer F")+"]", "application/json")
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status 413; got %d", rec.Code)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, body := range []string{"", "[" + valid("Batch Grocer G") + ","} {
+			if rec, _ := postBatch(t, body, "application/json"); rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %q; got %d", body, rec.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+)
+
+// config is the optional JSON file passed with -config.
+type config struct {
+	Rules         []string                            `json:"rules"`
+	Experiment    *receiptprocessor.Experiment        `json:"experiment"`
+	ExchangeRates map[string]string                   `json:"exchangeRates"`
+	Consistency   *receiptprocessor.ConsistencyPolicy `json:"consistency"`
+	Names         *receiptprocessor.NamePolicy        `json:"names"`
+	Duplicates    string                              `json:"duplicates"`
+	Fraud         *receiptprocessor.FraudPolicy       `json:"fraud"`
+	Workers       workerConfig                        `json:"workers"`
+	Batch         batchConfig                         `json:"batch"`
+	Idempotency   idempotencyConfig                   `json:"idempotency"`
+	Expiry        *receiptprocessor.ExpiryPolicy      `json:"expiry"`
+	Tiers         []receiptprocessor.Tier             `json:"tiers"`
+}
+
+// idempotencyConfig sets how long responses to requests with an Idempotency-Key are kept.
+type idempotencyConfig struct {
+	Retention string `json:"retention"`
+
+	retention time.Duration
+}
+
+var defaultIdempotency = idempotencyConfig{Retention: "24h", retention: 24 * time.Hour}
+
+// batchConfig limits POST /receipts/batch.
+type batchConfig struct {
+	MaxReceipts int `json:"maxReceipts"`
+}
+
+var defaultBatch = batchConfig{MaxReceipts: 5000}
+
+// workerConfig sizes the pool that scores receipts in the background.
+type workerConfig struct {
+	Count     int `json:"count"`
+	QueueSize int `json:"queueSize"`
+	// RetryAfterSeconds is sent to clients turned away because the queue is full.
+	RetryAfterSeconds int                           `json:"retryAfterSeconds"`
+	Retry             *receiptprocessor.RetryPolicy `json:"retry"`
+}
+
+var defaultWorkers = workerConfig{Count: 4, QueueSize: 100, RetryAfterSeconds: 5}
+
+// retryAfterSeconds is sent in the Retry-After header of a 503 when the queue is full.
+var retryAfterSeconds = defaultWorkers.RetryAfterSeconds
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// apply pushes the configuration into the processor.
+func (c *config) apply() error {
+	if len(c.Rules) > 0 {
+		if err := receiptprocessor.SetRules(c.Rules); err != nil {
+			return err
+		}
+	}
+	if err := receiptprocessor.SetExchangeRates(c.ExchangeRates); err != nil {
+		return err
+	}
+	if c.Consistency != nil {
+		if err := receiptprocessor.SetConsistencyPolicy(*c.Consistency); err != nil {
+			return err
+		}
+	}
+	if c.Names != nil {
+		if err := receiptprocessor.SetNamePolicy(*c.Names); err != nil {
+			return err
+		}
+	}
+	if err := receiptprocessor.SetDuplicatePolicy(c.Duplicates); err != nil {
+		return err
+	}
+	if c.Fraud != nil {
+		if err := receiptprocessor.SetFraudPolicy(*c.Fraud); err != nil {
+			return err
+		}
+	}
+	if c.Batch.MaxReceipts < 0 {
+		return fmt.Errorf("batch maxReceipts cannot be negative")
+	}
+	if c.Batch.MaxReceipts > 0 {
+		maxBatchReceipts = c.Batch.MaxReceipts
+	}
+	if c.Idempotency.Retention != "" {
+		retention, err := time.ParseDuration(c.Idempotency.Retention)
+		if err != nil || retention <= 0 {
+			return fmt.Errorf("idempotency retention: invalid duration %q", c.Idempotency.Retention)
+		}
+		idempotencyMu.Lock()
+		idempotencyRetention = retention
+		idempotencyMu.Unlock()
+	}
+	if c.Expiry != nil {
+		if err := receiptprocessor.SetExpiryPolicy(*c.Expiry); err != nil {
+			return err
+		}
+	}
+	if c.Tiers != nil {
+		if err := receiptprocessor.SetTiers(c.Tiers); err != nil {
+			return err
+		}
+	}
+	return receiptprocessor.SetExperiment(c.Experiment)
+}
+
+// startWorkers starts the scoring workers, using the defaults for any size that isn't configured.
+func (c *config) startWorkers() error {
+	workers := c.Workers
+	if workers.Count == 0 {
+		workers.Count = defaultWorkers.Count
+	}
+	if workers.QueueSize == 0 {
+		workers.QueueSize = defaultWorkers.QueueSize
+	}
+	if workers.RetryAfterSeconds > 0 {
+		retryAfterSeconds = workers.RetryAfterSeconds
+	}
+	if workers.Retry != nil {
+		if err := receiptprocessor.SetRetryPolicy(*workers.Retry); err != nil {
+			return err
+		}
+	}
+	return receiptprocessor.StartWorkers(workers.Count, workers.QueueSize)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// voidReceipt reverses the points of a receipt, e.g. after a refund.
+func voidReceipt(w http.ResponseWriter, r *http.Request) {
+	request := &pb.VoidRequest{}
+	data, err := io.ReadAll(r.Body)
+	if err != nil || protojson.Unmarshal(data, request) != nil {
+		writeError(w, http.StatusBadRequest, "The request is invalid.")
+		return
+	}
+
+	audit, err := receiptprocessor.VoidReceipt(r.PathValue("id"), request.Reason)
+	writeReceiptChange(w, audit, err)
+}
+
+// correctReceipt replaces a scored receipt with a corrected version, e.g. one reissued by the retailer.
+func correctReceipt(w http.ResponseWriter, r *http.Request) {
+	// the receipt is kept undecoded so it can be validated as it was sent
+	var request struct {
+		Receipt json.RawMessage `json:"receipt"`
+		Reason  string          `json:"reason"`
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil || json.Unmarshal(data, &request) != nil || request.Receipt == nil {
+		writeError(w, http.StatusBadRequest, "The request is invalid.")
+		return
+	}
+
+	corrected, errs, _ := receiptprocessor.ValidateReceiptJSON(request.Receipt)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	receipt, err := receiptprocessor.CorrectReceipt(r.PathValue("id"), corrected, request.Reason)
+	writeReceiptChange(w, receipt, err)
+}
+
+func getReceiptAudit(w http.ResponseWriter, r *http.Request) {
+	audit, err := receiptprocessor.GetReceiptAudit(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+	writeReceiptChange(w, audit, nil)
+}
+
+func writeReceiptChange(w http.ResponseWriter, message proto.Message, err error) {
+	switch {
+	case errors.Is(err, receiptprocessor.ErrReceiptNotFound):
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	case errors.Is(err, receiptprocessor.ErrChangeReasonRequired):
+		writeError(w, http.StatusBadRequest, "A reason is required to void or correct a receipt.")
+		return
+	case errors.Is(err, receiptprocessor.ErrReceiptVoided):
+		writeError(w, http.StatusConflict, "This receipt has been voided.")
+		return
+	case errors.Is(err, receiptprocessor.ErrReceiptBusy):
+		writeError(w, http.StatusConflict, "This receipt is still being processed. Please try again later.")
+		return
+	case errors.Is(err, receiptprocessor.ErrNotScored):
+		writeError(w, http.StatusConflict, "Only scored receipts can be corrected.")
+		return
+	case errors.Is(err, receiptprocessor.ErrDuplicateReceipt):
+		writeError(w, http.StatusConflict, "The corrected receipt has already been submitted.")
+		return
+	case err != nil:
+		log.Print(err)
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := protojson.Marshal(message)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestVoidAndCorrectReceipt(t *testing.T) {
+	mux := buildRouter()
+	rec := serve(t, mux, "POST", "/receipts/process", `{"retailer":"Dockside Fish","purchaseDate":"2022-06-10","purchaseTime":"12:10","items":[{"shortDescription":"Salmon","price":"12.10"}],"total":"12.10"}`)
+	response := ReceiptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	path := "/receipts/" + response.ID
+
+	t.Run("Correct", func(t *testing.T) {
+		rec := serve(t, mux, "PUT", path, `{"reason":"price typo","receipt":{"retailer":"Dockside Fish","purchaseDate":"2022-06-10","purchaseTime":"12:10","items":[{"shortDescription":"Salmon","price":"12.00"}],"total":"12.00"}}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d: %s", rec.Code, rec.Body.String())
+		}
+		stored := &pb.StoredReceipt{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), stored); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if stored.Receipt.Total != "12.00" {
+			t.Errorf("expected the corrected receipt, got %v", stored.Receipt)
+		}
+	})
+
+	t.Run("CorrectInvalid", func(t *testing.T) {
+		rec := serve(t, mux, "PUT", path, `{"reason":"typo","receipt":{"retailer":"Dockside Fish","purchaseDate":"2022-06-10","purchaseTime":"12:10","items":[],"total":"twelve"}}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400; got %d", rec.Code)
+		}
+	})
+
+	t.Run("Void", func(t *testing.T) {
+		if rec := serve(t, mux, "DELETE", path, `{}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 without a reason; got %d", rec.Code)
+		}
+		if rec := serve(t, mux, "DELETE", path, `{"reason":"refunded"}`); rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := serve(t, mux, "DELETE", path, `{"reason":"refunded"}`); rec.Code != http.StatusConflict {
+			t.Errorf("expected status 409 voiding twice; got %d", rec.Code)
+		}
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		rec := serve(t, mux, "GET", path+"/audit", "")
+		audit := &pb.ReceiptAudit{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), audit); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		kinds := []string{}
+		total := int32(0)
+		for _, entry := range audit.Entries {
+			kinds = append(kinds, entry.Kind)
+			total += entry.Points
+		}
+		if strings.Join(kinds, ",") != "award,correction,void" || total != 0 {
+			t.Errorf("expected award, correction and void entries summing to 0, got %v", audit.Entries)
+		}
+		if len(audit.Revisions) != 1 {
+			t.Errorf("expected one revision, got %v", audit.Revisions)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if rec := serve(t, mux, "DELETE", "/receipts/missing", `{"reason":"refunded"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404; got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// defaultExpiringDays is how far ahead GET /users/{id}/expiring looks without a days parameter.
+const defaultExpiringDays = 30
+
+// maxExpiringDays caps the days parameter at ten years.
+const maxExpiringDays = 3650
+
+// getExpiringPoints returns the points a user will lose in the next few days unless they spend them.
+func getExpiringPoints(w http.ResponseWriter, r *http.Request) {
+	days := defaultExpiringDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxExpiringDays {
+			writeError(w, http.StatusBadRequest, "days must be a whole number from 1 to 3650.")
+			return
+		}
+		days = parsed
+	}
+
+	before := time.Now().AddDate(0, 0, days)
+	response, err := protojson.Marshal(receiptprocessor.ExpiringPoints(r.PathValue("id"), before))
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// sweepExpiredPoints expires points every interval, for as long as the server runs.
+func sweepExpiredPoints(interval time.Duration) {
+	for at := range time.Tick(interval) {
+		if expired := receiptprocessor.ExpirePoints(at); expired > 0 {
+			log.Printf("Expired %d points", expired)
+		}
+	}
+}
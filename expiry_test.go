@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestGetExpiringPoints(t *testing.T) {
+	mux := buildRouter()
+	t.Run("Valid", func(t *testing.T) {
+		rec := serve(t, mux, "GET", "/users/expiry-http/expiring?days=90", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d: %s", rec.Code, rec.Body.String())
+		}
+		expiring := &pb.ExpiringPoints{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), expiring); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if expiring.UserId != "expiry-http" || expiring.Points != 0 || expiring.Before == "" {
+			t.Errorf("expected no expiring points for a new user, got %v", expiring)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, days := range []string{"0", "-5", "soon", "5000"} {
+			if rec := serve(t, mux, "GET", "/users/expiry-http/expiring?days="+days, ""); rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for days=%s; got %d", days, rec.Code)
+			}
+		}
+	})
+}
@@ -4,5 +4,7 @@ go 1.22.2
 
 require (
 	github.com/google/uuid v1.6.0
+	golang.org/x/text v0.21.0
 	google.golang.org/protobuf v1.36.4
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/keith-decker/fetch-assignment/kvstore"
+)
+
+// Responses to requests sent with an Idempotency-Key header are kept under
+// "idempotency-<hash of method, path, user and key>" for the retention window, so a client retrying after a
+// dropped connection gets the original response instead of submitting the receipt again.
+
+// idempotencyRecord is what is stored for a key. A record without a status belongs to a request
+// that is still being processed; its claim tells it apart from another request's.
+type idempotencyRecord struct {
+	BodyHash string    `json:"bodyHash"`
+	Claim    string    `json:"claim,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Body     string    `json:"body,omitempty"`
+	StoredAt time.Time `json:"storedAt"`
+}
+
+var (
+	idempotencyMu        sync.RWMutex
+	idempotencyRetention = defaultIdempotency.retention
+	// idempotencyInFlightTimeout is how long a request can hold a key before it is taken to have
+	// died, e.g. in a crash, and the key can be claimed again.
+	idempotencyInFlightTimeout = time.Minute
+)
+
+func currentIdempotencyRetention() time.Duration {
+	idempotencyMu.RLock()
+	defer idempotencyMu.RUnlock()
+	return idempotencyRetention
+}
+
+// idempotent runs handler at most once per Idempotency-Key, user and endpoint within the retention
+// window. The endpoint is the method and path, so the path's user ID is part of it.
+// A replay with the same body gets the stored response, a replay with a different body gets 422.
+// Responses that say the server couldn't handle the request, like a full queue, aren't kept, so
+// the client can retry with the same key.
+func idempotent(w http.ResponseWriter, r *http.Request, body []byte, handler func(http.ResponseWriter)) {
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if key == "" {
+		handler(w)
+		return
+	}
+
+	storeKey := idempotencyStoreKey(r.Method+" "+r.URL.Path, r.Header.Get("X-User-Id"), key)
+	bodyHash := fmt.Sprintf("%x", sha256.Sum256(body))
+	existing, claim, claimed := claimIdempotencyKey(storeKey, bodyHash)
+	if !claimed {
+		switch {
+		case existing.BodyHash != bodyHash:
+			writeError(w, http.StatusUnprocessableEntity, "This Idempotency-Key was already used with a different request.")
+		case existing.Status == 0:
+			writeError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed.")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Status)
+			w.Write([]byte(existing.Body))
+		}
+		return
+	}
+
+	kv := kvstore.New()
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	completed := false
+	defer func() {
+		// a failed or panicking request gives its key up, so the client can retry with it
+		if !completed {
+			kv.CompareAndDelete(storeKey, claim)
+		}
+	}()
+	handler(recorder)
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+	completed = true
+	data, err := json.Marshal(&idempotencyRecord{
+		BodyHash: bodyHash,
+		Status:   recorder.status,
+		Body:     recorder.body.String(),
+		StoredAt: time.Now().UTC(),
+	})
+	if err == nil {
+		// a request that ran past its timeout may have lost the key to a retry, which keeps it
+		kv.CompareAndSwap(storeKey, claim, string(data))
+	}
+}
+
+// claimIdempotencyKey stores an in-flight record for the key and returns it as the claim, or
+// returns the record already there. An expired record is swapped for the claim only if it hasn't
+// changed since it was read, so two requests can't both take it over.
+func claimIdempotencyKey(storeKey string, bodyHash string) (*idempotencyRecord, string, bool) {
+	kv := kvstore.New()
+	data, err := json.Marshal(&idempotencyRecord{BodyHash: bodyHash, Claim: uuid.New().String(), StoredAt: time.Now().UTC()})
+	if err != nil {
+		return nil, "", true
+	}
+	claim := string(data)
+	for {
+		stored, claimed := kv.SetIfAbsent(storeKey, claim)
+		if claimed {
+			return nil, claim, true
+		}
+		existing := &idempotencyRecord{}
+		if err := json.Unmarshal([]byte(stored), existing); err == nil && !idempotencyExpired(existing, time.Now()) {
+			return existing, "", false
+		}
+		if kv.CompareAndSwap(storeKey, stored, claim) {
+			return nil, claim, true
+		}
+	}
+}
+
+func idempotencyStoreKey(endpoint string, userID string, key string) string {
+	sum := sha256.Sum256([]byte(endpoint + "\x00" + userID + "\x00" + key))
+	return "idempotency-" + hex.EncodeToString(sum[:])
+}
+
+// idempotencyExpired reports whether a record is past the retention window, or for a request
+// still in flight, past the in-flight timeout.
+func idempotencyExpired(record *idempotencyRecord, at time.Time) bool {
+	if record.Status == 0 {
+		return at.Sub(record.StoredAt) > idempotencyInFlightTimeout
+	}
+	return at.Sub(record.StoredAt) > currentIdempotencyRetention()
+}
+
+// expireIdempotencyKeys removes the records that are past the retention window.
+func expireIdempotencyKeys(at time.Time) {
+	kv := kvstore.New()
+	for _, key := range kv.Keys("idempotency-") {
+		data, err := kv.Get(key)
+		if err != nil {
+			continue
+		}
+		record := &idempotencyRecord{}
+		if err := json.Unmarshal([]byte(data), record); err != nil || idempotencyExpired(record, at) {
+			kv.CompareAndDelete(key, data)
+		}
+	}
+}
+
+// sweepIdempotencyKeys expires old records every interval, for as long as the server runs.
+func sweepIdempotencyKeys(interval time.Duration) {
+	for at := range time.Tick(interval) {
+		expireIdempotencyKeys(at)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
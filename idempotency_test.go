@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func postWithIdempotencyKey(t *testing.T, mux http.Handler, key string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Idempotency-Key", key)
+	req.Header.Set("X-User-Id", "idempotency-user")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func receiptID(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	response := &pb.ProcessReceiptResponse{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	return response.Id
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	resetStore()
+	receipt := `{"retailer":"Harbor Bakery","purchaseDate":"2022-05-06","purchaseTime":"08:15","items":[{"shortDescription":"Sourdough","price":"6.00"}],"total":"6.00"}`
+	other := `{"retailer":"Harbor Bakery","purchaseDate":"2022-05-07","purchaseTime":"08:15","items":[{"shortDescription":"Rye","price":"5.00"}],"total":"5.00"}`
+	mux := buildRouter()
+
+	first := postWithIdempotencyKey(t, mux, "retry-1", receipt)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202; got %d", first.Code)
+	}
+	id := receiptID(t, first)
+
+	t.Run("Replay", func(t *testing.T) {
+		rec := postWithIdempotencyKey(t, mux, "retry-1", receipt)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected status 202; got %d", rec.Code)
+		}
+		if got := receiptID(t, rec); got != id {
+			t.Errorf("expected the original ID %s; got %s", id, got)
+		}
+		if rec.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("expected the response to be marked as replayed")
+		}
+	})
+
+	t.Run("DifferentBody", func(t *testing.T) {
+		rec := postWithIdempotencyKey(t, mux, "retry-1", other)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status 422; got %d", rec.Code)
+		}
+	})
+
+	t.Run("NewKey", func(t *testing.T) {
+		rec := postWithIdempotencyKey(t, mux, "retry-2", other)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("expected status 202; got %d", rec.Code)
+		}
+		if got := receiptID(t, rec); got == id {
+			t.Errorf("expected a new ID; got the original %s", got)
+		}
+	})
+
+	t.Run("AbandonedInFlight", func(t *testing.T) {
+		storeKey := idempotencyStoreKey("POST /receipts/process", "idempotency-user", "retry-abandoned")
+		kv := kvstore.New()
+		kv.Set(storeKey, fmt.Sprintf(`{"bodyHash":"crashed","claim":"old","storedAt":%q}`, time.Now().Add(-2*idempotencyInFlightTimeout).UTC().Format(time.RFC3339Nano)))
+		rec := postWithIdempotencyKey(t, mux, "retry-abandoned", other)
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("expected the key of a request that died to be claimed again; got status %d", rec.Code)
+		}
+
+		kv.Set(storeKey, fmt.Sprintf(`{"bodyHash":"running","claim":"new","storedAt":%q}`, time.Now().UTC().Format(time.RFC3339Nano)))
+		defer kv.Delete(storeKey)
+		if rec := postWithIdempotencyKey(t, mux, "retry-abandoned", other); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected a request still in flight to keep its key; got status %d", rec.Code)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expireIdempotencyKeys(time.Now().Add(defaultIdempotency.retention + time.Minute))
+		if keys := kvstore.New().Keys("idempotency-"); len(keys) != 0 {
+			t.Errorf("expected expired keys to be removed; got %d", len(keys))
+		}
+		rec := postWithIdempotencyKey(t, mux, "retry-1", other)
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("expected an expired key to be reusable; got status %d", rec.Code)
+		}
+	})
+}
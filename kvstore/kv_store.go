@@ -2,6 +2,11 @@ package kvstore
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -14,6 +19,8 @@ var (
 type KVStore struct {
 	mu    sync.RWMutex
 	store map[string]string
+	// log is the file changes are appended to once the store is opened with Open
+	log *os.File
 }
 
 func New() *KVStore {
@@ -39,10 +46,95 @@ func (kv *KVStore) Set(key, val string) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 	kv.store[key] = val
+	kv.persist(opSet, key, val)
+}
+
+// SetIfAbsent stores val at key unless the key already exists. It returns the value now
+// stored at key and whether it was set by this call.
+func (kv *KVStore) SetIfAbsent(key, val string) (string, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if existing, ok := kv.store[key]; ok {
+		return existing, false
+	}
+	kv.store[key] = val
+	kv.persist(opSet, key, val)
+	return val, true
+}
+
+// CompareAndSwap stores val at key only if the key holds old, and reports whether it did.
+func (kv *KVStore) CompareAndSwap(key, old, val string) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if existing, ok := kv.store[key]; !ok || existing != old {
+		return false
+	}
+	kv.store[key] = val
+	kv.persist(opSet, key, val)
+	return true
+}
+
+// CompareAndDelete removes key only if it holds old, and reports whether it did.
+func (kv *KVStore) CompareAndDelete(key, old string) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if existing, ok := kv.store[key]; !ok || existing != old {
+		return false
+	}
+	delete(kv.store, key)
+	kv.persist(opDelete, key, "")
+	return true
+}
+
+// Take removes the value at key and returns it. Only one of several concurrent callers gets the value.
+func (kv *KVStore) Take(key string) (string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	val, ok := kv.store[key]
+	if !ok {
+		return "", errors.New("key not found")
+	}
+	delete(kv.store, key)
+	kv.persist(opDelete, key, "")
+	return val, nil
+}
+
+// Keys returns every key that starts with prefix, in sorted order.
+func (kv *KVStore) Keys(prefix string) []string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	keys := []string{}
+	for key := range kv.store {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (kv *KVStore) Delete(key string) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 	delete(kv.store, key)
+	kv.persist(opDelete, key, "")
+}
+
+// Increment adds delta to the integer stored at key and returns the new value.
+// A missing key is treated as zero.
+func (kv *KVStore) Increment(key string, delta int) (int, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	current := 0
+	if val, ok := kv.store[key]; ok {
+		parsed, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, fmt.Errorf("value at %q is not an integer: %w", key, err)
+		}
+		current = parsed
+	}
+	current += delta
+	kv.store[key] = strconv.Itoa(current)
+	kv.persist(opSet, key, kv.store[key])
+	return current, nil
 }
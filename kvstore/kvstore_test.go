@@ -34,3 +34,82 @@ func TestKVStore(t *testing.T) {
 		}
 	})
 }
+
+func TestKVStoreIncrement(t *testing.T) {
+	t.Run("Increment missing key", func(t *testing.T) {
+		store := kvstore.New()
+		store.Delete("counter")
+		val, err := store.Increment("counter", 5)
+		if err != nil || val != 5 {
+			t.Errorf("expected 5, got %v (%v)", val, err)
+		}
+		val, err = store.Increment("counter", -2)
+		if err != nil || val != 3 {
+			t.Errorf("expected 3, got %v (%v)", val, err)
+		}
+	})
+
+	t.Run("Increment non-integer value", func(t *testing.T) {
+		store := kvstore.New()
+		store.Set("words", "not a number")
+		if _, err := store.Increment("words", 1); err == nil {
+			t.Error("expected an error incrementing a non-integer value")
+		}
+	})
+}
+
+func TestKVStoreSetIfAbsent(t *testing.T) {
+	store := kvstore.New()
+	store.Delete("once")
+	val, stored := store.SetIfAbsent("once", "first")
+	if !stored || val != "first" {
+		t.Errorf("expected first to be stored, got %v (%v)", val, stored)
+	}
+	val, stored = store.SetIfAbsent("once", "second")
+	if stored || val != "first" {
+		t.Errorf("expected first to be kept, got %v (%v)", val, stored)
+	}
+}
+
+func TestKVStoreCompareAndSwap(t *testing.T) {
+	store := kvstore.New()
+	store.Set("swap", "first")
+	if store.CompareAndSwap("swap", "other", "second") {
+		t.Error("expected no swap when the value differs")
+	}
+	if !store.CompareAndSwap("swap", "first", "second") {
+		t.Error("expected the value to be swapped")
+	}
+	if store.CompareAndDelete("swap", "first") {
+		t.Error("expected no delete when the value differs")
+	}
+	if !store.CompareAndDelete("swap", "second") {
+		t.Error("expected the key to be deleted")
+	}
+	if store.CompareAndSwap("swap", "", "third") {
+		t.Error("expected no swap for a missing key")
+	}
+}
+
+func TestKVStoreTake(t *testing.T) {
+	store := kvstore.New()
+	store.Set("once", "value")
+	val, err := store.Take("once")
+	if err != nil || val != "value" {
+		t.Errorf("expected value, got %v (%v)", val, err)
+	}
+	if _, err := store.Take("once"); err == nil {
+		t.Error("expected the key to be gone after Take")
+	}
+}
+
+func TestKVStoreKeys(t *testing.T) {
+	store := kvstore.New()
+	store.Set("prefix-b", "2")
+	store.Set("prefix-a", "1")
+	store.Set("other-a", "3")
+	keys := store.Keys("prefix-")
+	if len(keys) != 2 || keys[0] != "prefix-a" || keys[1] != "prefix-b" {
+		t.Errorf("expected [prefix-a prefix-b], got %v", keys)
+	}
+}
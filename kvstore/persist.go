@@ -0,0 +1,141 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// logEntry is one change in the store's log file, written as a line of JSON.
+type logEntry struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+const (
+	opSet    = "set"
+	opDelete = "delete"
+)
+
+// Open makes the store durable. It loads the log file at path, creating it if it doesn't exist,
+// and appends every later change to it, so the data survives a restart or a crash.
+func Open(path string) (*KVStore, error) {
+	kv := New()
+	if err := kv.attachLog(path); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// Close stops writing changes to the log file.
+func (kv *KVStore) Close() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.log == nil {
+		return nil
+	}
+	err := kv.log.Close()
+	kv.log = nil
+	return err
+}
+
+func (kv *KVStore) attachLog(path string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.log != nil {
+		return errors.New("the store is already open")
+	}
+
+	if err := kv.replay(path); err != nil {
+		return err
+	}
+	// rewrite the log with only the current values, so it doesn't grow forever
+	if err := kv.compact(path); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	kv.log = log
+	return nil
+}
+
+func (kv *KVStore) replay(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		entry := logEntry{}
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// a crash can leave the last entry half written, everything before it is kept
+			fmt.Printf("Error reading store log %s, ignoring the rest: %v\n", path, err)
+			return nil
+		}
+		switch entry.Op {
+		case opSet:
+			kv.store[entry.Key] = entry.Value
+		case opDelete:
+			delete(kv.store, entry.Key)
+		}
+	}
+}
+
+func (kv *KVStore) compact(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(kv.store))
+	for key := range kv.store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	encoder := json.NewEncoder(file)
+	for _, key := range keys {
+		if err := encoder.Encode(logEntry{Op: opSet, Key: key, Value: kv.store[key]}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// persist appends a change to the log file. The caller holds the write lock.
+func (kv *KVStore) persist(op string, key string, val string) {
+	if kv.log == nil {
+		return
+	}
+	data, err := json.Marshal(logEntry{Op: op, Key: key, Value: val})
+	if err != nil {
+		fmt.Printf("Error encoding store log entry for %s: %v\n", key, err)
+		return
+	}
+	if _, err := kv.log.Write(append(data, '\n')); err != nil {
+		fmt.Printf("Error writing store log entry for %s: %v\n", key, err)
+	}
+}
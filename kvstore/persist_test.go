@@ -0,0 +1,75 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore() *KVStore {
+	return &KVStore{store: make(map[string]string)}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+
+	t.Run("SurvivesRestart", func(t *testing.T) {
+		store := newTestStore()
+		if err := store.attachLog(path); err != nil {
+			t.Fatalf("could not open log: %v", err)
+		}
+		store.Set("kept", "1")
+		store.Set("deleted", "2")
+		store.Delete("deleted")
+		store.Increment("counter", 3)
+		store.SetIfAbsent("once", "first")
+		store.Set("taken", "4")
+		store.Take("taken")
+		store.Close()
+
+		reopened := newTestStore()
+		if err := reopened.attachLog(path); err != nil {
+			t.Fatalf("could not reopen log: %v", err)
+		}
+		defer reopened.Close()
+		for key, expected := range map[string]string{"kept": "1", "counter": "3", "once": "first"} {
+			if val, err := reopened.Get(key); err != nil || val != expected {
+				t.Errorf("expected %s at %s, got %q (%v)", expected, key, val, err)
+			}
+		}
+		for _, key := range []string{"deleted", "taken"} {
+			if _, err := reopened.Get(key); err == nil {
+				t.Errorf("expected %s to stay removed", key)
+			}
+		}
+	})
+
+	t.Run("IgnoresTornWrite", func(t *testing.T) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatalf("could not open log: %v", err)
+		}
+		file.WriteString(`{"op":"set","key":"half`)
+		file.Close()
+
+		store := newTestStore()
+		if err := store.attachLog(path); err != nil {
+			t.Fatalf("could not open log: %v", err)
+		}
+		defer store.Close()
+		if val, err := store.Get("kept"); err != nil || val != "1" {
+			t.Errorf("expected the entries before the torn write, got %q (%v)", val, err)
+		}
+	})
+
+	t.Run("OpenTwice", func(t *testing.T) {
+		store := newTestStore()
+		if err := store.attachLog(path); err != nil {
+			t.Fatalf("could not open log: %v", err)
+		}
+		defer store.Close()
+		if err := store.attachLog(path); err == nil {
+			t.Errorf("expected opening an open store to fail")
+		}
+	})
+}
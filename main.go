@@ -1,12 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/keith-decker/fetch-assignment/kvstore"
 	"github.com/keith-decker/fetch-assignment/pb"
@@ -20,15 +25,21 @@ func home(w http.ResponseWriter, r *http.Request) {
 
 func getPoints(w http.ResponseWriter, r *http.Request) {
 	receiptId := r.PathValue("id")
+	status, statusErr := receiptprocessor.GetReceiptStatus(receiptId)
 	points, err := getPointsFromStore(receiptId)
 
-	if err != nil {
+	// a receipt that isn't scored yet has a status but no points
+	if err != nil && statusErr != nil {
 		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
 		return
 	}
 
-	getResponse := &pb.GetPointsResponse{
-		Points: int32(points),
+	getResponse := &pb.GetPointsResponse{}
+	if err == nil {
+		getResponse.Points = int32(points)
+	}
+	if statusErr == nil {
+		getResponse.Status = status.Status
 	}
 
 	response, err := protojson.Marshal(getResponse)
@@ -40,11 +51,7 @@ func getPoints(w http.ResponseWriter, r *http.Request) {
 }
 
 func processReceipt(w http.ResponseWriter, r *http.Request) {
-	// Process the receipt
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-
+	// Process the receipt, the router only sends POSTs here
 	if r.Body == nil {
 		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
 		return
@@ -60,26 +67,39 @@ func processReceipt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// unmarshal the bytes into a receipt
-	receipt := &pb.Receipt{}
-	err = protojson.Unmarshal(data, receipt)
+	idempotent(w, r, data, func(w http.ResponseWriter) {
+		submitReceipt(w, r, data)
+	})
+}
 
-	if err != nil {
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
-		log.Print(err)
+// submitReceipt validates and processes the body of a POST /receipts/process.
+func submitReceipt(w http.ResponseWriter, r *http.Request, data []byte) {
+	receiptJSON, userID, errs := decodeSubmission(data, r.Header.Get("X-User-Id"))
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
 		return
 	}
 
-	// pass the receipt to the processor, return the ID
-	if !receiptprocessor.ValidateReceipt(receipt) {
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+	// validate the receipt as it was sent, then pass it to the processor, return the ID
+	receipt, errs, warnings := receiptprocessor.ValidateReceiptJSON(receiptJSON)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
 		return
 	}
 
-	id := receiptprocessor.ProcessReceipt(receipt)
+	id, err := receiptprocessor.ProcessReceiptForUser(userID, receipt)
+	if errors.Is(err, receiptprocessor.ErrDuplicateReceipt) {
+		writeError(w, http.StatusConflict, "This receipt has already been submitted.")
+		return
+	}
+	if errors.Is(err, receiptprocessor.ErrQueueFull) {
+		writeQueueFull(w)
+		return
+	}
 
 	processResponse := &pb.ProcessReceiptResponse{
-		Id: id,
+		Id:       id,
+		Warnings: toFieldErrors(warnings),
 	}
 
 	response, err := protojson.Marshal(processResponse)
@@ -87,13 +107,309 @@ func processReceipt(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
 		return
 	}
+	// the receipt is scored in the background, check its points or status for the result
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(response)
+}
+
+// decodeSubmission finds the receipt in a submission, sent on its own or wrapped as a
+// ProcessReceiptRequest with the ID of the user submitting it, and returns it undecoded so it can
+// be validated as sent. A user ID in the body must match the X-User-Id header, if there is one.
+func decodeSubmission(data []byte, headerUserID string) (json.RawMessage, string, receiptprocessor.ValidationErrors) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		log.Print(err)
+		return nil, "", receiptprocessor.ValidationErrors{{Path: "$", Code: receiptprocessor.CodeMalformed, Message: err.Error()}}
+	}
+	receipt, wrapped := fields["receipt"]
+	if !wrapped {
+		return data, headerUserID, nil
+	}
+
+	userID := ""
+	for name, value := range fields {
+		switch name {
+		case "receipt":
+		case "userId":
+			if err := json.Unmarshal(value, &userID); err != nil {
+				return nil, "", receiptprocessor.ValidationErrors{{Path: "$.userId", Code: receiptprocessor.CodeInvalid, Message: "must be a string"}}
+			}
+		default:
+			return nil, "", receiptprocessor.ValidationErrors{{Path: "$", Code: receiptprocessor.CodeMalformed, Message: fmt.Sprintf("unknown field %q", name)}}
+		}
+	}
+	userID = strings.TrimSpace(userID)
+	if headerUserID != "" && userID != "" && userID != headerUserID {
+		return nil, "", receiptprocessor.ValidationErrors{{Path: "$.userId", Code: receiptprocessor.CodeInvalid, Message: "does not match the X-User-Id header"}}
+	}
+	if userID == "" {
+		userID = headerUserID
+	}
+	return receipt, userID, nil
+}
+
+func getStatus(w http.ResponseWriter, r *http.Request) {
+	receiptId := r.PathValue("id")
+	status, err := receiptprocessor.GetReceiptStatus(receiptId)
+	if err != nil {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	response, err := protojson.Marshal(status)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+func getReceipt(w http.ResponseWriter, r *http.Request) {
+	receiptId := r.PathValue("id")
+	receipt, err := receiptprocessor.GetReceipt(receiptId)
+	if err != nil {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	response, err := protojson.Marshal(receipt)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+func getBreakdown(w http.ResponseWriter, r *http.Request) {
+	receiptId := r.PathValue("id")
+	breakdown, err := receiptprocessor.GetScoreBreakdown(receiptId)
+	if err != nil {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	response, err := protojson.Marshal(breakdown)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+func getRiskAssessment(w http.ResponseWriter, r *http.Request) {
+	receiptId := r.PathValue("id")
+	risk, err := receiptprocessor.GetRiskAssessment(receiptId)
+	if err != nil {
+		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		return
+	}
+
+	response, err := protojson.Marshal(risk)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+func listPendingReviews(w http.ResponseWriter, r *http.Request) {
+	reviews, err := receiptprocessor.PendingReviews()
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := protojson.Marshal(&pb.ReviewList{Reviews: reviews})
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
 	w.Write(response)
 }
 
+func approveReview(w http.ResponseWriter, r *http.Request) {
+	review, err := receiptprocessor.ApproveReceipt(r.PathValue("id"))
+	writeReviewDecision(w, review, err)
+}
+
+func rejectReview(w http.ResponseWriter, r *http.Request) {
+	request := &pb.ReviewDecisionRequest{}
+	data, err := io.ReadAll(r.Body)
+	if err != nil || protojson.Unmarshal(data, request) != nil {
+		writeError(w, http.StatusBadRequest, "The request is invalid.")
+		return
+	}
+
+	review, err := receiptprocessor.RejectReceipt(r.PathValue("id"), request.Reason)
+	writeReviewDecision(w, review, err)
+}
+
+func writeReviewDecision(w http.ResponseWriter, review *pb.ReviewItem, err error) {
+	switch {
+	case errors.Is(err, receiptprocessor.ErrNotPendingReview):
+		http.Error(w, "No receipt pending review for that ID.", http.StatusNotFound)
+		return
+	case errors.Is(err, receiptprocessor.ErrReviewReasonRequired):
+		writeError(w, http.StatusBadRequest, "A reason is required to reject a receipt.")
+		return
+	case errors.Is(err, receiptprocessor.ErrQueueFull):
+		// the receipt stays pending, so the approval can be retried
+		writeQueueFull(w)
+		return
+	case err != nil:
+		log.Print(err)
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := protojson.Marshal(review)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+// writeQueueFull turns a request away while the scoring queue is full, saying when to try again.
+func writeQueueFull(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	writeError(w, http.StatusServiceUnavailable, "Too many receipts are being processed. Please try again later.")
+}
+
+func listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	response, err := protojson.Marshal(&pb.ScoringJobList{Jobs: receiptprocessor.DeadLetters()})
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+func requeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	job, err := receiptprocessor.RequeueDeadLetter(r.PathValue("id"))
+	switch {
+	case errors.Is(err, receiptprocessor.ErrNotDeadLettered):
+		http.Error(w, "No dead-lettered receipt found for that ID.", http.StatusNotFound)
+		return
+	case errors.Is(err, receiptprocessor.ErrQueueFull):
+		writeQueueFull(w)
+		return
+	case err != nil:
+		log.Print(err)
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := protojson.Marshal(job)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(response)
+}
+
+func getExperimentReport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	arms, err := receiptprocessor.ExperimentReport(name)
+	if err != nil {
+		http.Error(w, "No experiment found with that name.", http.StatusNotFound)
+		return
+	}
+
+	report := &pb.ExperimentReport{Name: name}
+	for _, arm := range arms {
+		report.Arms = append(report.Arms, &pb.ArmReport{
+			Arm:      arm.Arm,
+			Receipts: int32(arm.Receipts),
+			Points:   int32(arm.Points),
+		})
+	}
+
+	response, err := protojson.Marshal(report)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+// writeValidationErrors responds with a 400 listing every invalid field.
+func writeValidationErrors(w http.ResponseWriter, errs receiptprocessor.ValidationErrors) {
+	errorResponse := &pb.ErrorResponse{
+		Message: "The receipt is invalid.",
+		Errors:  toFieldErrors(errs),
+	}
+
+	response, err := protojson.Marshal(errorResponse)
+	if err != nil {
+		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(response)
+}
+
+// writeError responds with a JSON error message and no field errors.
+func writeError(w http.ResponseWriter, status int, message string) {
+	response, err := protojson.Marshal(&pb.ErrorResponse{Message: message})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+func toFieldErrors(errs receiptprocessor.ValidationErrors) []*pb.FieldError {
+	fieldErrors := []*pb.FieldError{}
+	for _, err := range errs {
+		fieldErrors = append(fieldErrors, &pb.FieldError{
+			Path:    err.Path,
+			Code:    err.Code,
+			Message: err.Message,
+		})
+	}
+	return fieldErrors
+}
+
 func main() {
 	port := flag.String("port", "8080", "Port to run the server on")
+	configPath := flag.String("config", "", "Path to a JSON configuration file")
+	dataPath := flag.String("data", "", "Path to a file to keep receipts and queued jobs in across restarts")
+	reconcile := flag.Bool("reconcile", false, "Check the balances in the data file against the points ledger and exit")
 	flag.Parse()
 
+	if *dataPath != "" {
+		if _, err := kvstore.Open(*dataPath); err != nil {
+			log.Fatalf("Error opening data file: %v", err)
+		}
+	}
+	if *reconcile {
+		os.Exit(reconcileLedger())
+	}
+
+	cfg := &config{}
+	if *configPath != "" {
+		loaded, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+		cfg = loaded
+	}
+	if err := cfg.apply(); err != nil {
+		log.Fatalf("Error applying config: %v", err)
+	}
+	if err := cfg.startWorkers(); err != nil {
+		log.Fatalf("Error starting workers: %v", err)
+	}
+
+	go sweepIdempotencyKeys(time.Minute)
+	go sweepExpiredPoints(time.Hour)
+	go sweepTiers(time.Hour)
+
 	mux := buildRouter()
 	fmt.Printf("Starting server on port %s\n", *port)
 	if err := http.ListenAndServe(fmt.Sprintf(":%s", *port), mux); err != nil {
@@ -101,11 +417,54 @@ func main() {
 	}
 }
 
+// reconcileLedger prints the result of replaying the points ledger and returns the exit code,
+// 1 if anything disagrees with it.
+func reconcileLedger() int {
+	report := receiptprocessor.Reconcile()
+	fmt.Printf("Ledger entries: %d\n", report.Entries)
+	fmt.Printf("Points liability: %d\n", report.Liability)
+	if len(report.Mismatches) == 0 {
+		fmt.Println("The ledger reconciles.")
+		return 0
+	}
+	for _, mismatch := range report.Mismatches {
+		fmt.Println(mismatch)
+	}
+	fmt.Printf("%d mismatches found.\n", len(report.Mismatches))
+	return 1
+}
+
 func buildRouter() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/{$}", home)
+	mux.HandleFunc("GET /receipts", searchReceipts)
+	mux.HandleFunc("GET /receipts/{id}", getReceipt)
+	mux.HandleFunc("PUT /receipts/{id}", correctReceipt)
+	mux.HandleFunc("DELETE /receipts/{id}", voidReceipt)
+	mux.HandleFunc("GET /receipts/{id}/audit", getReceiptAudit)
 	mux.HandleFunc("/receipts/{id}/points", getPoints)
-	mux.HandleFunc("/receipts/process", processReceipt)
+	mux.HandleFunc("GET /receipts/{id}/status", getStatus)
+	mux.HandleFunc("GET /receipts/{id}/breakdown", getBreakdown)
+	mux.HandleFunc("GET /receipts/{id}/risk", getRiskAssessment)
+	mux.HandleFunc("POST /receipts/process", processReceipt)
+	mux.HandleFunc("POST /receipts/batch", processBatch)
+	mux.HandleFunc("GET /users/{id}/balance", getUserBalance)
+	mux.HandleFunc("GET /users/{id}/receipts", listUserReceipts)
+	mux.HandleFunc("GET /users/{id}/tier", getUserTier)
+	mux.HandleFunc("GET /users/{id}/expiring", getExpiringPoints)
+	mux.HandleFunc("GET /users/{id}/redemptions", listRedemptions)
+	mux.HandleFunc("POST /users/{id}/redemptions", redeemReward)
+	mux.HandleFunc("GET /rewards", listRewards)
+	mux.HandleFunc("GET /experiments/{name}", getExperimentReport)
+	mux.HandleFunc("GET /admin/reviews", listPendingReviews)
+	mux.HandleFunc("POST /admin/reviews/{id}/approve", approveReview)
+	mux.HandleFunc("POST /admin/reviews/{id}/reject", rejectReview)
+	mux.HandleFunc("GET /admin/rewards", listRewards)
+	mux.HandleFunc("POST /admin/rewards", addReward)
+	mux.HandleFunc("PUT /admin/rewards/{id}", updateReward)
+	mux.HandleFunc("DELETE /admin/rewards/{id}", removeReward)
+	mux.HandleFunc("GET /admin/dead-letters", listDeadLetters)
+	mux.HandleFunc("POST /admin/dead-letters/{id}/requeue", requeueDeadLetter)
 	return mux
 }
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -9,6 +10,9 @@ import (
 	"testing"
 
 	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 type ReceiptResponse struct {
@@ -19,6 +23,29 @@ type PointsResponse struct {
 	Points int `json:"points"`
 }
 
+// serve sends a request through the router, with headers given as pairs of name and value.
+func serve(t *testing.T, mux http.Handler, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// resetStore empties the store, so a test run again with -count starts from the same state.
+func resetStore() {
+	kv := kvstore.New()
+	for _, key := range kv.Keys("") {
+		kv.Delete(key)
+	}
+}
+
 func TestGetPoints(t *testing.T) {
 	kv := kvstore.New()
 	receiptId := "adb6b560-0eef-42bc-9d16-df48f30e89b2"
@@ -144,8 +171,8 @@ func TestProcessReceipt(t *testing.T) {
 	mux := buildRouter()
 	mux.ServeHTTP(rec, req)
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("expected status 200; got %d", rec.Code)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected status 202; got %d", rec.Code)
 	}
 
 	body := rec.Body.String()
@@ -176,4 +203,264 @@ func TestProcessReceiptInvalidRetailer(t *testing.T) {
 	if !strings.Contains(body, expected) {
 		t.Errorf("expected body to contain %q, got %q", expected, body)
 	}
+
+	errorResponse := &pb.ErrorResponse{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), errorResponse); err != nil {
+		t.Fatalf("could not decode response %q: %v", body, err)
+	}
+	// the body is validated as sent, so the blank retailer fails its pattern rather than looking missing
+	for _, path := range []string{"$.items[0].price", "$.retailer"} {
+		found := false
+		for _, fieldError := range errorResponse.Errors {
+			if fieldError.Path == path && fieldError.Code == "invalid_format" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected an invalid_format error for %s, got %v", path, errorResponse.Errors)
+		}
+	}
+}
+
+func TestGetBreakdown(t *testing.T) {
+	receipt := `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Mountain Dew 12PK","price":"6.49"}],"total":"6.49"}`
+	req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(receipt))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	mux := buildRouter()
+	mux.ServeHTTP(rec, req)
+
+	response := ReceiptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("/receipts/%s/breakdown", response.ID), nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200; got %d", rec.Code)
+	}
+
+	breakdown := &pb.ScoreBreakdown{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), breakdown); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	if len(breakdown.Stages) == 0 || breakdown.Stages[0].Rules[0].Rule != "retailer-alphanumeric" || breakdown.Stages[0].Rules[0].Points != 6 {
+		t.Errorf("expected retailer-alphanumeric to award 6 points first, got %v", breakdown)
+	}
+}
+
+func TestGetReceipt(t *testing.T) {
+	receipt := `{"retailer":"Lakeside Books","purchaseDate":"2022-04-09","purchaseTime":"15:20","items":[{"shortDescription":"Atlas","price":"20.00"}],"total":"20.00"}`
+	req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(receipt))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	mux := buildRouter()
+	mux.ServeHTTP(rec, req)
+
+	response := ReceiptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+
+	t.Run("Found", func(t *testing.T) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/receipts/%s", response.ID), nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d", rec.Code)
+		}
+		stored := &pb.StoredReceipt{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), stored); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if stored.Receipt.GetRetailer() != "Lakeside Books" || len(stored.Receipt.GetItems()) != 1 {
+			t.Errorf("expected the submitted receipt, got %v", stored.Receipt)
+		}
+		if stored.Status != receiptprocessor.StatusScored || stored.SubmittedAt == "" || stored.RuleSetVersion == "" {
+			t.Errorf("expected a scored receipt with its submission time and rule set version, got %v", stored)
+		}
+		if stored.Points == 0 {
+			t.Errorf("expected points, got %v", stored)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/receipts/missing", nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404; got %d", rec.Code)
+		}
+	})
+}
+
+func TestProcessReceiptDuplicate(t *testing.T) {
+	resetStore()
+	if err := receiptprocessor.SetDuplicatePolicy(receiptprocessor.DuplicatesReject); err != nil {
+		t.Fatalf("could not set duplicate policy: %v", err)
+	}
+	defer receiptprocessor.SetDuplicatePolicy(receiptprocessor.DuplicatesReturnOriginal)
+
+	receipt := `{"retailer":"Corner Deli","purchaseDate":"2022-02-02","purchaseTime":"12:00","items":[{"shortDescription":"Bagel","price":"2.50"}],"total":"2.50"}`
+	mux := buildRouter()
+	for i, expected := range []int{http.StatusAccepted, http.StatusConflict} {
+		req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(receipt))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("submission %d: expected status %d; got %d", i+1, expected, rec.Code)
+		}
+	}
+}
+
+func TestReviewEndpoints(t *testing.T) {
+	resetStore()
+	mux := buildRouter()
+	ids := []string{}
+	for _, purchaseTime := range []string{"10:00", "10:02"} {
+		receipt := fmt.Sprintf(`{"retailer":"Review Bakery","purchaseDate":"2022-06-01","purchaseTime":"%s","items":[{"shortDescription":"Scone","price":"3.00"}],"total":"3.00"}`, purchaseTime)
+		req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(receipt))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("X-User-Id", "review-http")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		response := ReceiptResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		ids = append(ids, response.ID)
+	}
+	held := ids[1]
+
+	req, _ := http.NewRequest("GET", "/admin/reviews", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	reviews := &pb.ReviewList{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), reviews); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	found := false
+	for _, review := range reviews.Reviews {
+		if review.Id == held {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %s in the review queue, got %v", held, reviews.Reviews)
+	}
+
+	for _, test := range []struct {
+		body     string
+		expected int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"reason":"duplicate upload"}`, http.StatusOK},
+		{`{"reason":"duplicate upload"}`, http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reviews/%s/reject", held), strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("rejecting with %s: expected status %d; got %d", test.body, test.expected, rec.Code)
+		}
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	resetStore()
+	mux := buildRouter()
+	var id string
+	for _, purchaseTime := range []string{"18:00", "18:01"} {
+		receipt := fmt.Sprintf(`{"retailer":"Status Diner","purchaseDate":"2022-06-02","purchaseTime":"%s","items":[{"shortDescription":"Pie","price":"4.00"}],"total":"4.00"}`, purchaseTime)
+		req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(receipt))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("X-User-Id", "status-http")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		response := ReceiptResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		id = response.ID
+	}
+
+	// the second receipt is a near-duplicate, held for review with no points yet
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/receipts/%s/status", id), nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	status := &pb.ReceiptStatus{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), status); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	if status.Status != "pending_review" || len(status.History) != 3 {
+		t.Errorf("expected pending_review after 3 transitions, got %v", status)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/receipts/%s/points", id), nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	points := &pb.GetPointsResponse{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), points); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || points.Status != "pending_review" || points.Points != 0 {
+		t.Errorf("expected no points while pending review, got %d %v", rec.Code, points)
+	}
+
+	req, _ = http.NewRequest("GET", "/receipts/missing/status", nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404; got %d", rec.Code)
+	}
+}
+
+func TestDeadLetterEndpoints(t *testing.T) {
+	mux := buildRouter()
+
+	req, _ := http.NewRequest("GET", "/admin/dead-letters", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200; got %d", rec.Code)
+	}
+	jobs := &pb.ScoringJobList{}
+	if err := protojson.Unmarshal(rec.Body.Bytes(), jobs); err != nil {
+		t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+	}
+
+	req, _ = http.NewRequest("POST", "/admin/dead-letters/missing/requeue", nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404; got %d", rec.Code)
+	}
 }
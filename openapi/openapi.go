@@ -0,0 +1,278 @@
+// Package openapi loads request schemas from an OpenAPI 3 document and validates decoded
+// JSON against them, so the API spec is the single source of truth for request formats.
+//
+// Only the parts of JSON Schema the API uses are supported: type, required, properties,
+// items, pattern, format (date and time), enum, minItems/maxItems, minLength/maxLength
+// and local $ref to components/schemas.
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error codes reported by Validate.
+const (
+	CodeRequired = "required"
+	CodeInvalid  = "invalid_format"
+	CodeMinItems = "min_items"
+	CodeMaxItems = "max_items"
+)
+
+// Error is one problem with a value, addressed by its JSON path, e.g. "$.items[0].price".
+type Error struct {
+	Path    string
+	Code    string
+	Message string
+}
+
+// Schema is a JSON schema from the OpenAPI document.
+type Schema struct {
+	Ref         string             `yaml:"$ref"`
+	Type        string             `yaml:"type"`
+	Required    []string           `yaml:"required"`
+	Properties  map[string]*Schema `yaml:"properties"`
+	Items       *Schema            `yaml:"items"`
+	Pattern     string             `yaml:"pattern"`
+	Format      string             `yaml:"format"`
+	Enum        []string           `yaml:"enum"`
+	MinItems    *int               `yaml:"minItems"`
+	MaxItems    *int               `yaml:"maxItems"`
+	MinLength   *int               `yaml:"minLength"`
+	MaxLength   *int               `yaml:"maxLength"`
+	Description string             `yaml:"description"`
+
+	pattern *regexp.Regexp
+}
+
+type mediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+type operation struct {
+	RequestBody struct {
+		Content map[string]mediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+}
+
+// Spec is a loaded OpenAPI document.
+type Spec struct {
+	Paths      map[string]map[string]operation `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// Load parses an OpenAPI document and resolves its schema references.
+func Load(data []byte) (*Spec, error) {
+	spec := &Spec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI document: %w", err)
+	}
+	for name, schema := range spec.Components.Schemas {
+		if err := spec.resolve(schema, map[*Schema]bool{}); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	for path, operations := range spec.Paths {
+		for method, op := range operations {
+			for _, content := range op.RequestBody.Content {
+				if err := spec.resolve(content.Schema, map[*Schema]bool{}); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+			}
+		}
+	}
+	return spec, nil
+}
+
+// RequestSchema returns the JSON request body schema for an operation, e.g. ("/receipts/process", "post").
+func (s *Spec) RequestSchema(path string, method string) (*Schema, error) {
+	op, ok := s.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("no %s operation for %s", method, path)
+	}
+	content, ok := op.RequestBody.Content["application/json"]
+	if !ok || content.Schema == nil {
+		return nil, fmt.Errorf("%s %s has no application/json request body", method, path)
+	}
+	return s.deref(content.Schema), nil
+}
+
+// Property returns the schema of a named property, or nil.
+func (s *Schema) Property(name string) *Schema {
+	if s == nil {
+		return nil
+	}
+	return s.Properties[name]
+}
+
+// resolve compiles the patterns and checks the references of a schema and everything below it.
+func (s *Spec) resolve(schema *Schema, seen map[*Schema]bool) error {
+	if schema == nil || seen[schema] {
+		return nil
+	}
+	seen[schema] = true
+	if schema.Ref != "" {
+		target := s.lookup(schema.Ref)
+		if target == nil {
+			return fmt.Errorf("unresolved reference %q", schema.Ref)
+		}
+		return s.resolve(target, seen)
+	}
+	if schema.Pattern != "" {
+		pattern, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", schema.Pattern, err)
+		}
+		schema.pattern = pattern
+	}
+	for name, property := range schema.Properties {
+		if err := s.resolve(property, seen); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		schema.Properties[name] = s.deref(property)
+	}
+	if schema.Items != nil {
+		if err := s.resolve(schema.Items, seen); err != nil {
+			return fmt.Errorf("items: %w", err)
+		}
+		schema.Items = s.deref(schema.Items)
+	}
+	return nil
+}
+
+func (s *Spec) lookup(ref string) *Schema {
+	name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+	if !ok {
+		return nil
+	}
+	return s.Components.Schemas[name]
+}
+
+func (s *Spec) deref(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.lookup(schema.Ref)
+	}
+	return schema
+}
+
+// Validate checks a value decoded by encoding/json against the schema.
+func (s *Schema) Validate(value any) []Error {
+	errs := []Error{}
+	s.validate("$", value, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, value any, errs *[]Error) {
+	add := func(code string, format string, args ...any) {
+		*errs = append(*errs, Error{Path: path, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			add(CodeInvalid, "%s must be an object", fieldName(path))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				*errs = append(*errs, Error{Path: path + "." + name, Code: CodeRequired, Message: name + " is required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := object[name]; ok {
+				s.Properties[name].validate(path+"."+name, property, errs)
+			}
+		}
+
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			add(CodeInvalid, "%s must be an array", fieldName(path))
+			return
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			add(CodeMinItems, "%s must have at least %d items", fieldName(path), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(array) > *s.MaxItems {
+			add(CodeMaxItems, "%s must have at most %d items", fieldName(path), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			add(CodeInvalid, "%s must be a string", fieldName(path))
+			return
+		}
+		length := len([]rune(str))
+		if s.MinLength != nil && length < *s.MinLength {
+			add(CodeInvalid, "%s must be at least %d characters", fieldName(path), *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			add(CodeInvalid, "%s must be at most %d characters", fieldName(path), *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			add(CodeInvalid, "%s must match %s", fieldName(path), s.Pattern)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			add(CodeInvalid, "%s must be one of %s", fieldName(path), strings.Join(s.Enum, ", "))
+		}
+		switch s.Format {
+		case "date":
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				add(CodeInvalid, "%s must be a date like 2022-01-31", fieldName(path))
+			}
+		case "time":
+			if _, err := time.Parse("15:04", str); err != nil {
+				add(CodeInvalid, "%s must be a 24-hour time like 13:01", fieldName(path))
+			}
+		}
+
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			add(CodeInvalid, "%s must be a number", fieldName(path))
+		} else if s.Type == "integer" && number != float64(int64(number)) {
+			add(CodeInvalid, "%s must be an integer", fieldName(path))
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			add(CodeInvalid, "%s must be a boolean", fieldName(path))
+		}
+	}
+}
+
+// fieldName is the last segment of a JSON path, for messages.
+func fieldName(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[i+1:]
+	}
+	return "the value"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
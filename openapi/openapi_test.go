@@ -0,0 +1,157 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/openapi"
+)
+
+const testSpec = `
+openapi: 3.0.3
+paths:
+    /things:
+        post:
+            requestBody:
+                content:
+                    application/json:
+                        schema:
+                            $ref: "#/components/schemas/Thing"
+components:
+    schemas:
+        Thing:
+            type: object
+            required:
+                - name
+                - parts
+            properties:
+                name:
+                    type: string
+                    pattern: "^[a-z]+$"
+                day:
+                    type: string
+                    format: date
+                at:
+                    type: string
+                    format: time
+                color:
+                    type: string
+                    enum:
+                        - red
+                        - blue
+                count:
+                    type: integer
+                parts:
+                    type: array
+                    minItems: 1
+                    items:
+                        $ref: "#/components/schemas/Part"
+        Part:
+            type: object
+            required:
+                - label
+            properties:
+                label:
+                    type: string
+                    maxLength: 3
+`
+
+func decode(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("could not decode %s: %v", data, err)
+	}
+	return value
+}
+
+func TestValidate(t *testing.T) {
+	spec, err := openapi.Load([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("could not load spec: %v", err)
+	}
+	schema, err := spec.RequestSchema("/things", "POST")
+	if err != nil {
+		t.Fatalf("could not find schema: %v", err)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		errs := schema.Validate(decode(t, `{"name":"widget","day":"2022-01-31","at":"23:59","color":"red","count":3,"parts":[{"label":"abc"}]}`))
+		if len(errs) != 0 {
+			t.Errorf("expected no errors, got %v", errs)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		errs := schema.Validate(decode(t, `{"name":"Widget!","day":"2022-02-30","at":"99:99","color":"green","count":1.5,"parts":[{"label":"abcd"},{}]}`))
+		expected := map[string]string{
+			"$.name":           openapi.CodeInvalid,
+			"$.day":            openapi.CodeInvalid,
+			"$.at":             openapi.CodeInvalid,
+			"$.color":          openapi.CodeInvalid,
+			"$.count":          openapi.CodeInvalid,
+			"$.parts[0].label": openapi.CodeInvalid,
+			"$.parts[1].label": openapi.CodeRequired,
+		}
+		if len(errs) != len(expected) {
+			t.Errorf("expected %d errors, got %v", len(expected), errs)
+		}
+		for _, err := range errs {
+			if expected[err.Path] != err.Code {
+				t.Errorf("expected %s to have code %q, got %q", err.Path, expected[err.Path], err.Code)
+			}
+		}
+	})
+
+	t.Run("MissingAndEmpty", func(t *testing.T) {
+		errs := schema.Validate(decode(t, `{"parts":[]}`))
+		if len(errs) != 2 || errs[0].Path != "$.name" || errs[0].Code != openapi.CodeRequired || errs[1].Code != openapi.CodeMinItems {
+			t.Errorf("expected name required and parts min items, got %v", errs)
+		}
+	})
+
+	t.Run("WrongTypes", func(t *testing.T) {
+		errs := schema.Validate(decode(t, `{"name":5,"parts":{}}`))
+		if len(errs) != 2 {
+			t.Errorf("expected 2 type errors, got %v", errs)
+		}
+		if errs := schema.Validate(decode(t, `[]`)); len(errs) != 1 || errs[0].Path != "$" {
+			t.Errorf("expected the root to be rejected, got %v", errs)
+		}
+	})
+}
+
+func TestLoadErrors(t *testing.T) {
+	badSpecs := map[string]string{
+		"unresolved reference": `
+components:
+    schemas:
+        Thing:
+            type: object
+            properties:
+                part:
+                    $ref: "#/components/schemas/Missing"
+`,
+		"invalid pattern": `
+components:
+    schemas:
+        Thing:
+            type: string
+            pattern: "([a-z"
+`,
+		"invalid yaml": `components: [`,
+	}
+	for name, spec := range badSpecs {
+		if _, err := openapi.Load([]byte(spec)); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+
+	spec, err := openapi.Load([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("could not load spec: %v", err)
+	}
+	if _, err := spec.RequestSchema("/missing", "post"); err == nil {
+		t.Errorf("expected an error for a missing path")
+	}
+}
@@ -0,0 +1,136 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Severity decides what happens when a consistency check fails.
+type Severity string
+
+const (
+	// SeverityOff skips the check.
+	SeverityOff Severity = "off"
+	// SeverityWarn accepts the receipt and reports the problem as a warning.
+	SeverityWarn Severity = "warn"
+	// SeverityFail rejects the receipt.
+	SeverityFail Severity = "fail"
+)
+
+// Codes for consistency problems.
+const (
+	CodeItemSumMismatch = "item_sum_mismatch"
+	CodeFutureDate      = "future_date"
+)
+
+// ConsistencyPolicy configures the checks that compare fields against each other.
+type ConsistencyPolicy struct {
+	// ItemSum checks that the item prices add up to the total.
+	ItemSum Severity `json:"itemSum"`
+	// ItemSumTolerance is how far, in base currency, the items may be from the total to allow for tax and discount lines.
+	ItemSumTolerance string `json:"itemSumTolerance"`
+	// ItemSumTolerancePercent is an alternative tolerance as a whole percentage of the total. The larger tolerance wins.
+	ItemSumTolerancePercent int64 `json:"itemSumTolerancePercent"`
+	// FutureDate checks that the purchase date is not after today.
+	FutureDate Severity `json:"futureDate"`
+	// FutureDateGraceDays allows purchase dates slightly ahead of the server clock, for time zones.
+	FutureDateGraceDays int `json:"futureDateGraceDays"`
+	// ReviewWarnings holds receipts with warnings for manual review instead of scoring them.
+	ReviewWarnings bool `json:"reviewWarnings"`
+
+	itemSumTolerance Money
+}
+
+// DefaultConsistencyPolicy warns about item sums that don't match and rejects dates in the future.
+var DefaultConsistencyPolicy = ConsistencyPolicy{
+	ItemSum:             SeverityWarn,
+	FutureDate:          SeverityFail,
+	FutureDateGraceDays: 1,
+}
+
+var (
+	consistencyMu     sync.RWMutex
+	consistencyPolicy = DefaultConsistencyPolicy
+	// now is swapped out in tests
+	now = time.Now
+)
+
+// SetConsistencyPolicy validates and activates a consistency policy.
+func SetConsistencyPolicy(policy ConsistencyPolicy) error {
+	for name, severity := range map[string]Severity{"itemSum": policy.ItemSum, "futureDate": policy.FutureDate} {
+		switch severity {
+		case SeverityOff, SeverityWarn, SeverityFail:
+		default:
+			return fmt.Errorf("consistency %s: unknown severity %q", name, severity)
+		}
+	}
+	if policy.ItemSumTolerance != "" {
+		tolerance, err := ParseMoney(policy.ItemSumTolerance)
+		if err != nil {
+			return fmt.Errorf("consistency itemSumTolerance: %w", err)
+		}
+		policy.itemSumTolerance = tolerance
+	}
+	if policy.ItemSumTolerancePercent > 100 {
+		return fmt.Errorf("consistency itemSumTolerancePercent cannot be more than 100")
+	}
+	if policy.ItemSumTolerancePercent < 0 || policy.FutureDateGraceDays < 0 {
+		return fmt.Errorf("consistency tolerances cannot be negative")
+	}
+
+	consistencyMu.Lock()
+	consistencyPolicy = policy
+	consistencyMu.Unlock()
+	return nil
+}
+
+// checkConsistency runs the cross-field checks on a receipt whose fields are individually valid.
+func checkConsistency(receipt *ParsedReceipt) (errs ValidationErrors, warnings ValidationErrors) {
+	consistencyMu.RLock()
+	policy := consistencyPolicy
+	consistencyMu.RUnlock()
+
+	report := func(severity Severity, path string, code string, format string, args ...any) {
+		switch severity {
+		case SeverityFail:
+			errs.add(path, code, format, args...)
+		case SeverityWarn:
+			warnings.add(path, code, format, args...)
+		}
+	}
+
+	if policy.ItemSum != SeverityOff {
+		var sum Money
+		for _, item := range receipt.Items {
+			// every amount is at most MaxMoney, so the sum can only overflow with millions of items
+			if sum > math.MaxInt64-item.Price {
+				sum = math.MaxInt64
+				break
+			}
+			sum += item.Price
+		}
+		difference := sum - receipt.Total
+		if difference < 0 {
+			difference = -difference
+		}
+		tolerance := policy.itemSumTolerance
+		if percent := receipt.Total * Money(policy.ItemSumTolerancePercent) / 100; percent > tolerance {
+			tolerance = percent
+		}
+		if difference > tolerance {
+			report(policy.ItemSum, "$.total", CodeItemSumMismatch, "items add up to %s but the total is %s", sum, receipt.Total)
+		}
+	}
+
+	if policy.FutureDate != SeverityOff {
+		today := now()
+		latest := time.Date(today.Year(), today.Month(), today.Day()+policy.FutureDateGraceDays, 0, 0, 0, 0, time.UTC)
+		if receipt.PurchaseDate.After(latest) {
+			report(policy.FutureDate, "$.purchaseDate", CodeFutureDate, "purchaseDate %s is in the future", receipt.PurchaseDate.Format("2006-01-02"))
+		}
+	}
+
+	return errs, warnings
+}
@@ -0,0 +1,115 @@
+package receiptprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func consistencyReceipt() *pb.Receipt {
+	return &pb.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "10.00",
+		Items: []*pb.Item{
+			{ShortDescription: "Gum", Price: "4.00"},
+			{ShortDescription: "Soda", Price: "5.00"},
+		},
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	defer SetConsistencyPolicy(DefaultConsistencyPolicy)
+	now = func() time.Time { return time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	t.Run("RejectsBadPolicy", func(t *testing.T) {
+		if err := SetConsistencyPolicy(ConsistencyPolicy{ItemSum: "sometimes", FutureDate: SeverityOff}); err == nil {
+			t.Errorf("expected an unknown severity to be rejected")
+		}
+		if err := SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityWarn, FutureDate: SeverityOff, ItemSumTolerance: "lots"}); err == nil {
+			t.Errorf("expected an invalid tolerance to be rejected")
+		}
+		if err := SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityWarn, FutureDate: SeverityOff, ItemSumTolerancePercent: 101}); err == nil {
+			t.Errorf("expected a tolerance of more than the total to be rejected")
+		}
+	})
+
+	t.Run("HugeAmounts", func(t *testing.T) {
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityWarn, FutureDate: SeverityOff})
+		receipt := consistencyReceipt()
+		receipt.Items = []*pb.Item{{ShortDescription: "Yacht", Price: "92233720368547758.07"}, {ShortDescription: "Jet", Price: "92233720368547758.07"}}
+		errs, warnings := ValidateReceiptWithWarnings(receipt)
+		if len(errs) != 2 || errs[0].Path != "$.items[0].price" {
+			t.Errorf("expected the prices to be rejected as too large, got %v", errs)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("expected no item sum warning, got %v", warnings)
+		}
+	})
+
+	t.Run("ItemSumWarns", func(t *testing.T) {
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityWarn, FutureDate: SeverityOff})
+		errs, warnings := ValidateReceiptWithWarnings(consistencyReceipt())
+		if len(errs) != 0 {
+			t.Errorf("expected no errors, got %v", errs)
+		}
+		if len(warnings) != 1 || warnings[0].Code != CodeItemSumMismatch {
+			t.Errorf("expected an item sum warning, got %v", warnings)
+		}
+	})
+
+	t.Run("ItemSumFails", func(t *testing.T) {
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityFail, FutureDate: SeverityOff})
+		errs, warnings := ValidateReceiptWithWarnings(consistencyReceipt())
+		if len(errs) != 1 || errs[0].Path != "$.total" {
+			t.Errorf("expected an item sum error, got %v", errs)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("expected no warnings, got %v", warnings)
+		}
+	})
+
+	t.Run("ItemSumTolerance", func(t *testing.T) {
+		// one dollar of tax on a ten dollar receipt
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityFail, FutureDate: SeverityOff, ItemSumTolerance: "1.00"})
+		if errs := ValidateReceipt(consistencyReceipt()); len(errs) != 0 {
+			t.Errorf("expected the absolute tolerance to cover the tax, got %v", errs)
+		}
+
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityFail, FutureDate: SeverityOff, ItemSumTolerancePercent: 10})
+		if errs := ValidateReceipt(consistencyReceipt()); len(errs) != 0 {
+			t.Errorf("expected the percent tolerance to cover the tax, got %v", errs)
+		}
+
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityFail, FutureDate: SeverityOff, ItemSumTolerance: "0.99", ItemSumTolerancePercent: 9})
+		if errs := ValidateReceipt(consistencyReceipt()); len(errs) != 1 {
+			t.Errorf("expected the tax to be outside both tolerances, got %v", errs)
+		}
+	})
+
+	t.Run("FutureDate", func(t *testing.T) {
+		SetConsistencyPolicy(ConsistencyPolicy{ItemSum: SeverityOff, FutureDate: SeverityFail, FutureDateGraceDays: 1})
+		receipt := consistencyReceipt()
+
+		receipt.PurchaseDate = "2022-06-16"
+		if errs := ValidateReceipt(receipt); len(errs) != 0 {
+			t.Errorf("expected tomorrow to be within the grace period, got %v", errs)
+		}
+
+		receipt.PurchaseDate = "2022-06-17"
+		if errs := ValidateReceipt(receipt); len(errs) != 1 || errs[0].Code != CodeFutureDate {
+			t.Errorf("expected a future date error, got %v", errs)
+		}
+	})
+
+	t.Run("RealTimeOfDay", func(t *testing.T) {
+		receipt := consistencyReceipt()
+		receipt.PurchaseTime = "99:99"
+		if errs := ValidateReceipt(receipt); len(errs) != 1 || errs[0].Path != "$.purchaseTime" {
+			t.Errorf("expected 99:99 to be rejected, got %v", errs)
+		}
+	})
+}
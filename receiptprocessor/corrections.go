@@ -0,0 +1,248 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// A receipt's points are never overwritten. Scoring it posts an award to the ledger, and voiding
+// or correcting it posts a compensating entry for the difference, so the points are always the
+// sum of its entries. Every earlier version of a corrected receipt is kept under "receipt-<ID>-audit".
+
+// Kinds of points entries.
+const (
+	EntryAward      = "award"
+	EntryVoid       = "void"
+	EntryCorrection = "correction"
+)
+
+var (
+	// ErrReceiptNotFound is returned when changing a receipt that doesn't exist.
+	ErrReceiptNotFound = errors.New("receipt not found")
+	// ErrReceiptVoided is returned when changing a receipt that is already voided.
+	ErrReceiptVoided = errors.New("receipt is voided")
+	// ErrReceiptBusy is returned when changing a receipt that is still being validated or scored.
+	ErrReceiptBusy = errors.New("receipt is still being processed")
+	// ErrNotScored is returned when correcting a receipt that was never scored.
+	ErrNotScored = errors.New("only scored receipts can be corrected")
+	// ErrChangeReasonRequired is returned when voiding or correcting a receipt without saying why.
+	ErrChangeReasonRequired = errors.New("a reason is required to void or correct a receipt")
+)
+
+// changeMu serializes voids and corrections, so two of them can't compensate the same points.
+var changeMu sync.Mutex
+
+// VoidReceipt reverses the points of a receipt and marks it voided. A receipt waiting for review
+// is taken off the queue and one that ran out of scoring attempts off the dead-letter list.
+// Its fingerprint is released, so a reissued copy of the receipt can be submitted.
+func VoidReceipt(id string, reason string) (*pb.ReceiptAudit, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrChangeReasonRequired
+	}
+	changeMu.Lock()
+	defer changeMu.Unlock()
+
+	status, err := GetReceiptStatus(id)
+	if err != nil {
+		return nil, ErrReceiptNotFound
+	}
+	kv := kvstore.New()
+	switch status.Status {
+	case StatusVoided:
+		return nil, ErrReceiptVoided
+	case StatusReceived, StatusValidating, StatusProcessing:
+		return nil, ErrReceiptBusy
+	case StatusPendingReview:
+		if _, err := decideReview(id, ReviewRejected, "voided: "+reason); err != nil {
+			return nil, ErrReceiptBusy
+		}
+	case StatusFailed:
+		kv.Delete(fmt.Sprintf("deadletter-%s", id))
+	}
+
+	voidPoints(id, reason)
+	if fingerprint, err := kv.Get(fmt.Sprintf("receipt-%s-fingerprint", id)); err == nil {
+		releaseFingerprint(fingerprint, id)
+	}
+	setStatus(id, StatusVoided, reason)
+	return GetReceiptAudit(id)
+}
+
+// CorrectReceipt replaces a scored receipt with a corrected version and rescores it with the
+// rules and tier multiplier it was scored with, recording the change in points as a correction entry. The corrected
+// receipt must not duplicate another one. Like a void, a correction never takes back more points
+// than the holder has left.
+func CorrectReceipt(id string, receipt *pb.Receipt, reason string) (*pb.StoredReceipt, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrChangeReasonRequired
+	}
+	NormalizeReceipt(receipt)
+	parsed, err := parseReceipt(receipt)
+	if err != nil {
+		return nil, err
+	}
+	changeMu.Lock()
+	defer changeMu.Unlock()
+
+	status, err := GetReceiptStatus(id)
+	if err != nil {
+		return nil, ErrReceiptNotFound
+	}
+	switch status.Status {
+	case StatusScored:
+	case StatusVoided:
+		return nil, ErrReceiptVoided
+	case StatusReceived, StatusValidating, StatusProcessing:
+		return nil, ErrReceiptBusy
+	default:
+		return nil, ErrNotScored
+	}
+	previous, err := storedReceipt(id)
+	if err != nil {
+		return nil, ErrReceiptNotFound
+	}
+
+	kv := kvstore.New()
+	fingerprint := Fingerprint(receipt)
+	oldFingerprint, _ := kv.Get(fmt.Sprintf("receipt-%s-fingerprint", id))
+	if fingerprint != oldFingerprint {
+		if original, claimed := claimFingerprint(fingerprint, id); !claimed {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateReceipt, original)
+		}
+		releaseFingerprint(oldFingerprint, id)
+		kv.Set(fmt.Sprintf("receipt-%s-fingerprint", id), fingerprint)
+	}
+
+	breakdown := tallyScore(parsed, pipelineForReceipt(id))
+	// experiments compare rule sets, so their results leave out the tier multiplier
+	ruleTotal := int(breakdown.Total)
+	if scored, err := GetScoreBreakdown(id); err == nil && scored.Tier != "" {
+		if hundredths, err := parseMultiplier(scored.TierMultiplier); err == nil {
+			applyTier(breakdown, Tier{Name: scored.Tier, Multiplier: scored.TierMultiplier, hundredths: hundredths})
+		}
+	}
+	data, err := protojson.Marshal(breakdown)
+	if err != nil {
+		return nil, fmt.Errorf("storing breakdown: %w", err)
+	}
+
+	audit := loadAudit(id)
+	audit.Revisions = append(audit.Revisions, &pb.ReceiptRevision{
+		Receipt:    previous,
+		ReplacedAt: now().UTC().Format(time.RFC3339Nano),
+		Reason:     reason,
+	})
+	saveAudit(id, audit)
+
+	unindexReceiptFields(id, previous)
+	if stored, err := protojson.Marshal(receipt); err == nil {
+		kv.Set(fmt.Sprintf("receipt-%s-receipt", id), string(stored))
+	}
+	indexReceiptFields(id, receipt)
+	kv.Set(fmt.Sprintf("receipt-%s-breakdown", id), string(data))
+
+	balanceMu.Lock()
+	change := int(breakdown.Total) - storedPoints(id)
+	if change < 0 {
+		// points the holder already spent can't be taken back, so they stay with the receipt
+		change = -takeablePoints(userAccount(receiptUser(id)), -change)
+	}
+	postReceiptPoints(id, EntryCorrection, change, reason, breakdown.RuleSetVersion)
+	balanceMu.Unlock()
+	if experiment, arm := receiptExperimentArm(id); arm != nil {
+		recordExperimentResult(id, experiment.Name, arm.Name, ruleTotal)
+	}
+	setStatus(id, StatusScored, "corrected: "+reason)
+	return GetReceipt(id)
+}
+
+// GetReceiptAudit returns the ledger entries, earlier versions and status history of a receipt.
+// Entries are shown from the point of view of whoever holds the receipt's points.
+func GetReceiptAudit(id string) (*pb.ReceiptAudit, error) {
+	status, err := GetReceiptStatus(id)
+	if err != nil {
+		return nil, err
+	}
+	audit := loadAudit(id)
+	for _, entry := range receiptLedger(id) {
+		points := entry.Amount
+		if entry.Credit == AccountPointsIssued {
+			points = -points
+		}
+		audit.Entries = append(audit.Entries, &pb.PointsEntry{
+			Kind:           entry.Kind,
+			Points:         points,
+			At:             entry.At,
+			Reason:         entry.Reason,
+			RuleSetVersion: entry.RuleSetVersion,
+		})
+	}
+	audit.History = status.History
+	return audit, nil
+}
+
+// awardPoints posts the points of a newly scored receipt. A receipt scored again, e.g. after a
+// restart, gets a correction for any difference instead of a second award. The ledger decides
+// whether the receipt was scored before, since the process can stop after posting an entry but
+// before recording the receipt's total.
+func awardPoints(id string, breakdown *pb.ScoreBreakdown) {
+	kv := kvstore.New()
+	posted, found := postedPoints(id)
+	if _, err := kv.Get(fmt.Sprintf("receipt-%s", id)); err != nil && !found {
+		postReceiptPoints(id, EntryAward, int(breakdown.Total), "", breakdown.RuleSetVersion)
+		return
+	}
+	if posted != storedPoints(id) {
+		// catch the receipt's total up with the entries posted before the process stopped
+		setReceiptPoints(id, posted)
+	}
+	postReceiptPoints(id, EntryCorrection, int(breakdown.Total)-posted, "scored again", breakdown.RuleSetVersion)
+}
+
+// storedPoints returns the current points of a receipt, 0 if it has none.
+func storedPoints(id string) int {
+	kv := kvstore.New()
+	data, err := kv.Get(fmt.Sprintf("receipt-%s", id))
+	if err != nil {
+		return 0
+	}
+	points, _ := strconv.Atoi(data)
+	return points
+}
+
+// pipelineForReceipt returns the rules a receipt was scored with: its experiment arm while that
+// experiment is still running, otherwise the default rules.
+func pipelineForReceipt(id string) *scoringPipeline {
+	if _, arm := receiptExperimentArm(id); arm != nil {
+		return arm.pipeline
+	}
+	return currentPipeline()
+}
+
+func loadAudit(id string) *pb.ReceiptAudit {
+	kv := kvstore.New()
+	audit := &pb.ReceiptAudit{Id: id}
+	if data, err := kv.Get(fmt.Sprintf("receipt-%s-audit", id)); err == nil {
+		if err := protojson.Unmarshal([]byte(data), audit); err != nil {
+			fmt.Printf("Error reading audit of receipt %s: %v\n", id, err)
+		}
+	}
+	return audit
+}
+
+func saveAudit(id string, audit *pb.ReceiptAudit) {
+	kv := kvstore.New()
+	if data, err := protojson.Marshal(audit); err == nil {
+		kv.Set(fmt.Sprintf("receipt-%s-audit", id), string(data))
+	}
+}
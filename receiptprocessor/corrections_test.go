@@ -0,0 +1,155 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func entryPoints(t *testing.T, id string) []int32 {
+	t.Helper()
+	audit, err := GetReceiptAudit(id)
+	if err != nil {
+		t.Fatalf("could not get audit: %v", err)
+	}
+	points := []int32{}
+	for _, entry := range audit.Entries {
+		points = append(points, entry.Points)
+	}
+	return points
+}
+
+func TestVoidReceipt(t *testing.T) {
+	resetStore()
+	t.Run("Scored", func(t *testing.T) {
+		receipt := fraudReceipt("Void Grocer", "11:00", "8.25")
+		id, err := ProcessReceipt(receipt)
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		awarded := storedPoints(id)
+		if awarded == 0 {
+			t.Fatalf("expected the receipt to score points")
+		}
+
+		audit, err := VoidReceipt(id, "refunded")
+		if err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		if len(audit.Entries) != 2 || audit.Entries[1].Kind != EntryVoid || int(audit.Entries[1].Points) != -awarded {
+			t.Errorf("expected an award and a compensating void entry, got %v", audit.Entries)
+		}
+		if points := storedPoints(id); points != 0 {
+			t.Errorf("expected a voided receipt to have 0 points, got %d", points)
+		}
+		expectHistory(t, statusHistory(t, id), StatusReceived, StatusValidating, StatusProcessing, StatusScored, StatusVoided)
+
+		if _, err := VoidReceipt(id, "again"); !errors.Is(err, ErrReceiptVoided) {
+			t.Errorf("expected ErrReceiptVoided, got %v", err)
+		}
+		// the fingerprint is released, so a reissued receipt is accepted as new
+		reissued, err := ProcessReceipt(fraudReceipt("Void Grocer", "11:00", "8.25"))
+		if err != nil || reissued == id {
+			t.Errorf("expected the reissued receipt to get a new ID, got %s, %v", reissued, err)
+		}
+	})
+
+	t.Run("PendingReview", func(t *testing.T) {
+		id := holdReceipt(t, "Void Deli")
+		if _, err := VoidReceipt(id, "customer withdrew it"); err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		if isPending(id) {
+			t.Errorf("expected a voided receipt to leave the review queue")
+		}
+	})
+
+	t.Run("ReasonRequired", func(t *testing.T) {
+		if _, err := VoidReceipt("missing", " "); !errors.Is(err, ErrChangeReasonRequired) {
+			t.Errorf("expected ErrChangeReasonRequired, got %v", err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := VoidReceipt("missing", "refunded"); !errors.Is(err, ErrReceiptNotFound) {
+			t.Errorf("expected ErrReceiptNotFound, got %v", err)
+		}
+	})
+}
+
+func TestCorrectReceipt(t *testing.T) {
+	resetStore()
+	id, err := ProcessReceipt(fraudReceipt("Correction Cafe", "11:00", "4.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	before := storedPoints(id)
+
+	// a round total adds the round-dollar and quarter bonuses
+	corrected := fraudReceipt("Correction Cafe", "11:00", "4.00")
+	stored, err := CorrectReceipt(id, corrected, "retailer reissued the receipt")
+	if err != nil {
+		t.Fatalf("could not correct receipt: %v", err)
+	}
+	if stored.Receipt.Total != "4.00" {
+		t.Errorf("expected the corrected receipt to be stored, got %v", stored.Receipt)
+	}
+	if int(stored.Points) != before+75 {
+		t.Errorf("expected %d points, got %d", before+75, stored.Points)
+	}
+
+	entries := entryPoints(t, id)
+	if len(entries) != 2 || entries[0] != int32(before) || entries[1] != 75 {
+		t.Errorf("expected the award and a correction of 75, got %v", entries)
+	}
+	audit, err := GetReceiptAudit(id)
+	if err != nil {
+		t.Fatalf("could not get audit: %v", err)
+	}
+	if len(audit.Revisions) != 1 || audit.Revisions[0].Receipt.Total != "4.10" {
+		t.Errorf("expected the original receipt to be kept as a revision, got %v", audit.Revisions)
+	}
+
+	t.Run("Duplicate", func(t *testing.T) {
+		other, err := ProcessReceipt(fraudReceipt("Correction Corner", "12:00", "3.10"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		if _, err := CorrectReceipt(other, fraudReceipt("Correction Cafe", "11:00", "4.00"), "typo"); !errors.Is(err, ErrDuplicateReceipt) {
+			t.Errorf("expected ErrDuplicateReceipt, got %v", err)
+		}
+	})
+
+	t.Run("AfterRedemption", func(t *testing.T) {
+		spender, err := ProcessReceiptForUser("correction-spender", fraudReceipt("Correction Cellar", "08:00", "100.00"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		account := userAccount("correction-spender")
+		if _, err := AddReward(&pb.Reward{Id: "correction-crate", Name: "Crate", Cost: int32(AccountBalance(account) - 17), Inventory: 1}); err != nil {
+			t.Fatalf("could not add reward: %v", err)
+		}
+		if _, err := Redeem("correction-spender", "correction-crate"); err != nil {
+			t.Fatalf("could not redeem reward: %v", err)
+		}
+		if _, err := CorrectReceipt(spender, fraudReceipt("Correction Cellar", "08:00", "1.01"), "total was mistyped"); err != nil {
+			t.Fatalf("could not correct receipt: %v", err)
+		}
+		if balance := AccountBalance(account); balance != 0 {
+			t.Errorf("expected the correction to take back no more than the 17 points left, got a balance of %d", balance)
+		}
+		if found := mismatchesFor(Reconcile(), account); len(found) > 0 {
+			t.Errorf("expected no mismatches, got %v", found)
+		}
+	})
+
+	t.Run("Voided", func(t *testing.T) {
+		if _, err := VoidReceipt(id, "refunded"); err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		if _, err := CorrectReceipt(id, corrected, "typo"); !errors.Is(err, ErrReceiptVoided) {
+			t.Errorf("expected ErrReceiptVoided, got %v", err)
+		}
+	})
+}
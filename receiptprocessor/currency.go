@@ -0,0 +1,136 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// BaseCurrency is the currency every amount is normalized to before scoring.
+const BaseCurrency = "USD"
+
+// minorUnits is the number of decimal places each supported ISO-4217 currency uses.
+var minorUnits = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"OMR": 3,
+	"TND": 3,
+	"USD": 2,
+}
+
+var amountRegexes = map[int]*regexp.Regexp{
+	0: regexp.MustCompile(`^\d+$`),
+	2: regexp.MustCompile(`^\d+\.\d{2}$`),
+	3: regexp.MustCompile(`^\d+\.\d{3}$`),
+}
+
+var (
+	exchangeRateMu sync.RWMutex
+	// exchangeRates holds the value of one unit of each currency in the base currency.
+	exchangeRates = map[string]*big.Rat{BaseCurrency: big.NewRat(1, 1)}
+)
+
+// SetExchangeRates replaces the exchange-rate table. Rates are decimal strings giving the
+// value of one unit of the currency in the base currency, e.g. {"JPY": "0.0067"}.
+func SetExchangeRates(rates map[string]string) error {
+	table := map[string]*big.Rat{BaseCurrency: big.NewRat(1, 1)}
+	for code, rate := range rates {
+		if _, ok := minorUnits[code]; !ok {
+			return fmt.Errorf("unsupported currency %q", code)
+		}
+		parsed, ok := new(big.Rat).SetString(rate)
+		if !ok || parsed.Sign() <= 0 {
+			return fmt.Errorf("invalid exchange rate for %s: %q", code, rate)
+		}
+		if code == BaseCurrency && parsed.Cmp(big.NewRat(1, 1)) != 0 {
+			return fmt.Errorf("the exchange rate for the base currency %s must be 1", BaseCurrency)
+		}
+		table[code] = parsed
+	}
+
+	exchangeRateMu.Lock()
+	exchangeRates = table
+	exchangeRateMu.Unlock()
+	return nil
+}
+
+// receiptCurrency returns the currency code for a receipt, defaulting to the base currency.
+func receiptCurrency(code string) (string, error) {
+	if code == "" {
+		return BaseCurrency, nil
+	}
+	code = strings.ToUpper(code)
+	if _, ok := minorUnits[code]; !ok {
+		return "", fmt.Errorf("unsupported currency %q", code)
+	}
+	exchangeRateMu.RLock()
+	_, ok := exchangeRates[code]
+	exchangeRateMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("no exchange rate configured for %s", code)
+	}
+	return code, nil
+}
+
+// parseMinorUnits parses an amount written with exactly the given number of decimal places
+// into an integer count of minor units.
+func parseMinorUnits(amount string, places int) (int64, error) {
+	regex, ok := amountRegexes[places]
+	if !ok || !regex.MatchString(amount) {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	minor, ok := new(big.Int).SetString(strings.Replace(amount, ".", "", 1), 10)
+	if !ok || !minor.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", errMoneyOverflow, amount)
+	}
+	return minor.Int64(), nil
+}
+
+// parseAmount parses an amount in the given currency and converts it to base currency cents,
+// rounding half a cent up.
+func parseAmount(amount string, code string) (Money, error) {
+	places := minorUnits[code]
+	minor, err := parseMinorUnits(amount, places)
+	if err != nil {
+		return 0, err
+	}
+	if code == BaseCurrency {
+		if Money(minor) > MaxMoney {
+			return 0, fmt.Errorf("%w: %q", errMoneyOverflow, amount)
+		}
+		return Money(minor), nil
+	}
+
+	exchangeRateMu.RLock()
+	rate, ok := exchangeRates[code]
+	exchangeRateMu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate configured for %s", code)
+	}
+
+	// minor / 10^places gives whole units, * rate gives base units, * 100 gives cents
+	cents := new(big.Rat).SetInt64(minor)
+	cents.Mul(cents, rate)
+	cents.Mul(cents, big.NewRat(100, 1))
+	cents.Quo(cents, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)))
+
+	// amounts are never negative, so (2n + d) / 2d rounds half up
+	rounded := new(big.Int).Mul(cents.Num(), big.NewInt(2))
+	rounded.Add(rounded, cents.Denom())
+	rounded.Quo(rounded, new(big.Int).Mul(cents.Denom(), big.NewInt(2)))
+	if !rounded.IsInt64() || Money(rounded.Int64()) > MaxMoney {
+		return 0, fmt.Errorf("%w: %q", errMoneyOverflow, amount)
+	}
+	return Money(rounded.Int64()), nil
+}
@@ -0,0 +1,117 @@
+package receiptprocessor
+
+import (
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func TestCurrencies(t *testing.T) {
+	if err := SetExchangeRates(map[string]string{"CAD": "0.75", "JPY": "0.0067", "KWD": "3.25"}); err != nil {
+		t.Fatalf("could not set exchange rates: %v", err)
+	}
+	defer SetExchangeRates(nil)
+
+	t.Run("RejectsBadRates", func(t *testing.T) {
+		for _, rates := range []map[string]string{
+			{"XYZ": "1"},
+			{"CAD": "abc"},
+			{"CAD": "-1"},
+			{"USD": "2"},
+		} {
+			if err := SetExchangeRates(rates); err == nil {
+				t.Errorf("expected %v to be rejected", rates)
+			}
+		}
+	})
+
+	t.Run("ParseAmount", func(t *testing.T) {
+		cases := []struct {
+			amount   string
+			currency string
+			expected Money
+		}{
+			{"12.34", "USD", 1234},
+			{"10.00", "CAD", 750},
+			{"0.01", "CAD", 1},
+			{"1500", "JPY", 1005},
+			{"1", "JPY", 1},
+			{"1.000", "KWD", 325},
+			{"0.001", "KWD", 0},
+			{"0.002", "KWD", 1},
+		}
+		for _, c := range cases {
+			result, err := parseAmount(c.amount, c.currency)
+			if err != nil {
+				t.Errorf("could not parse %s %s: %v", c.amount, c.currency, err)
+				continue
+			}
+			if result != c.expected {
+				t.Errorf("expected %s %s to be %d cents, got %d", c.amount, c.currency, c.expected, result)
+			}
+		}
+
+		invalid := []struct {
+			amount   string
+			currency string
+		}{
+			{"1500.00", "JPY"},
+			{"12.34", "KWD"},
+			{"12.345", "CAD"},
+			{"12", "USD"},
+		}
+		for _, c := range invalid {
+			if _, err := parseAmount(c.amount, c.currency); err == nil {
+				t.Errorf("expected %s %s to be rejected", c.amount, c.currency)
+			}
+		}
+	})
+
+	t.Run("ValidateReceipt", func(t *testing.T) {
+		receipt := &pb.Receipt{
+			Retailer:     "Lawson",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Currency:     "JPY",
+			Total:        "1500",
+			Items:        []*pb.Item{{ShortDescription: "Onigiri", Price: "1500"}},
+		}
+		if errs := ValidateReceipt(receipt); len(errs) > 0 {
+			t.Errorf("expected a JPY receipt to be valid, got %v", errs)
+		}
+
+		receipt.Total = "15.00"
+		if errs := ValidateReceipt(receipt); len(errs) != 1 || errs[0].Path != "$.total" {
+			t.Errorf("expected a JPY receipt with cents to have a total error, got %v", errs)
+		}
+
+		receipt.Currency = "GBP"
+		receipt.Total = "15.00"
+		receipt.Items[0].Price = "15.00"
+		if errs := ValidateReceipt(receipt); len(errs) != 1 || errs[0].Code != CodeUnsupported {
+			t.Errorf("expected a currency without an exchange rate to be unsupported, got %v", errs)
+		}
+	})
+
+	t.Run("RulesUseBaseCurrency", func(t *testing.T) {
+		// 1000 JPY is 6.70 USD, which is neither a round dollar nor a multiple of 0.25
+		receipt, err := parseReceipt(&pb.Receipt{
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Currency:     "JPY",
+			Total:        "1000",
+		})
+		if err != nil {
+			t.Fatalf("could not parse receipt: %v", err)
+		}
+		if receipt.Total != 670 {
+			t.Errorf("expected 670 cents, got %d", receipt.Total)
+		}
+		if result := rule2.Process(receipt); result != 0 {
+			t.Errorf("expected 0, got %d", result)
+		}
+		if result := rule3.Process(receipt); result != 0 {
+			t.Errorf("expected 0, got %d", result)
+		}
+	})
+}
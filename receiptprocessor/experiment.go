@@ -0,0 +1,224 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+)
+
+const (
+	// AssignByReceipt buckets each receipt independently using its ID.
+	AssignByReceipt = "receipt"
+	// AssignByUser keeps every receipt from a user in the same arm. Receipts without a user fall back to the receipt ID.
+	AssignByUser = "user"
+)
+
+// Experiment splits receipts between arms, each scored with its own rule set.
+type Experiment struct {
+	Name     string          `json:"name"`
+	AssignBy string          `json:"assignBy"`
+	Arms     []ExperimentArm `json:"arms"`
+}
+
+// ExperimentArm is one slice of traffic in an experiment. Weight is relative to the other arms.
+type ExperimentArm struct {
+	Name   string   `json:"name"`
+	Weight int      `json:"weight"`
+	Rules  []string `json:"rules"`
+
+	pipeline *scoringPipeline
+}
+
+// ArmReport is the aggregate scoring for one arm of an experiment.
+type ArmReport struct {
+	Arm      string
+	Receipts int
+	Points   int
+}
+
+var (
+	experimentMu     sync.RWMutex
+	activeExperiment *Experiment
+	// experimentResultMu keeps a receipt's recorded points in step with its arm's counters.
+	experimentResultMu sync.Mutex
+)
+
+// SetExperiment validates and activates an experiment. Passing nil turns experiments off
+// and scores every receipt with the default rules.
+func SetExperiment(experiment *Experiment) error {
+	if experiment == nil {
+		experimentMu.Lock()
+		activeExperiment = nil
+		experimentMu.Unlock()
+		return nil
+	}
+
+	if experiment.Name == "" {
+		return errors.New("experiment name is required")
+	}
+	switch experiment.AssignBy {
+	case "":
+		experiment.AssignBy = AssignByReceipt
+	case AssignByReceipt, AssignByUser:
+	default:
+		return fmt.Errorf("experiment %s: unknown assignBy %q", experiment.Name, experiment.AssignBy)
+	}
+	if len(experiment.Arms) == 0 {
+		return fmt.Errorf("experiment %s: at least one arm is required", experiment.Name)
+	}
+
+	seen := map[string]bool{}
+	for i := range experiment.Arms {
+		arm := &experiment.Arms[i]
+		if arm.Name == "" {
+			return fmt.Errorf("experiment %s: arm %d has no name", experiment.Name, i)
+		}
+		if seen[arm.Name] {
+			return fmt.Errorf("experiment %s: duplicate arm %q", experiment.Name, arm.Name)
+		}
+		seen[arm.Name] = true
+		if arm.Weight <= 0 {
+			return fmt.Errorf("experiment %s: arm %s must have a positive weight", experiment.Name, arm.Name)
+		}
+		rules, err := rulesByName(arm.Rules)
+		if err != nil {
+			return fmt.Errorf("experiment %s: arm %s: %w", experiment.Name, arm.Name, err)
+		}
+		pipeline, err := newScoringPipeline(rules)
+		if err != nil {
+			return fmt.Errorf("experiment %s: arm %s: %w", experiment.Name, arm.Name, err)
+		}
+		arm.pipeline = pipeline
+	}
+
+	experimentMu.Lock()
+	activeExperiment = experiment
+	experimentMu.Unlock()
+	return nil
+}
+
+// assignExperimentArm deterministically picks the arm for a receipt. It returns nil if no experiment is running.
+func assignExperimentArm(receiptID string, userID string) (*Experiment, *ExperimentArm) {
+	experimentMu.RLock()
+	experiment := activeExperiment
+	experimentMu.RUnlock()
+	if experiment == nil {
+		return nil, nil
+	}
+
+	key := receiptID
+	if experiment.AssignBy == AssignByUser && userID != "" {
+		key = userID
+	}
+	return experiment, experiment.armFor(key)
+}
+
+func (e *Experiment) armFor(key string) *ExperimentArm {
+	totalWeight := 0
+	for _, arm := range e.Arms {
+		totalWeight += arm.Weight
+	}
+
+	// salt with the experiment name so a new experiment reshuffles the traffic
+	hash := fnv.New32a()
+	hash.Write([]byte(e.Name + ":" + key))
+	bucket := int(hash.Sum32() % uint32(totalWeight))
+
+	for i := range e.Arms {
+		bucket -= e.Arms[i].Weight
+		if bucket < 0 {
+			return &e.Arms[i]
+		}
+	}
+	return &e.Arms[len(e.Arms)-1]
+}
+
+// recordExperimentResult counts a receipt in its arm the first time it is scored there. Scoring it
+// again, e.g. after a restart, or correcting it only moves the arm's points by the change, so the
+// points it was last recorded with are kept under "receipt-<ID>-arm-points".
+func recordExperimentResult(receiptID string, experiment string, arm string, points int) {
+	experimentResultMu.Lock()
+	defer experimentResultMu.Unlock()
+
+	kv := kvstore.New()
+	assigned := fmt.Sprintf("%s/%s", experiment, arm)
+	change := points
+	if recorded, err := kv.Get(fmt.Sprintf("receipt-%s-arm", receiptID)); err == nil && recorded == assigned {
+		change -= getCounter(kv, fmt.Sprintf("receipt-%s-arm-points", receiptID))
+	} else {
+		kv.Set(fmt.Sprintf("receipt-%s-arm", receiptID), assigned)
+		if _, err := kv.Increment(fmt.Sprintf("experiment-%s-%s-receipts", experiment, arm), 1); err != nil {
+			fmt.Printf("Error recording experiment receipt count: %v\n", err)
+		}
+	}
+	kv.Set(fmt.Sprintf("receipt-%s-arm-points", receiptID), strconv.Itoa(points))
+	if _, err := kv.Increment(fmt.Sprintf("experiment-%s-%s-points", experiment, arm), change); err != nil {
+		fmt.Printf("Error recording experiment points: %v\n", err)
+	}
+}
+
+// receiptExperimentArm returns the arm a receipt was scored under, or nil if that experiment is no
+// longer running.
+func receiptExperimentArm(receiptID string) (*Experiment, *ExperimentArm) {
+	assigned, err := GetReceiptArm(receiptID)
+	if err != nil {
+		return nil, nil
+	}
+	experimentMu.RLock()
+	experiment := activeExperiment
+	experimentMu.RUnlock()
+	if experiment == nil {
+		return nil, nil
+	}
+	for i := range experiment.Arms {
+		if assigned == fmt.Sprintf("%s/%s", experiment.Name, experiment.Arms[i].Name) {
+			return experiment, &experiment.Arms[i]
+		}
+	}
+	return nil, nil
+}
+
+// GetReceiptArm returns the "experiment/arm" a receipt was scored under.
+func GetReceiptArm(receiptID string) (string, error) {
+	kv := kvstore.New()
+	return kv.Get(fmt.Sprintf("receipt-%s-arm", receiptID))
+}
+
+// ExperimentReport returns the aggregate points per arm for the active experiment.
+func ExperimentReport(name string) ([]ArmReport, error) {
+	experimentMu.RLock()
+	experiment := activeExperiment
+	experimentMu.RUnlock()
+	if experiment == nil || experiment.Name != name {
+		return nil, fmt.Errorf("experiment %q is not running", name)
+	}
+
+	kv := kvstore.New()
+	reports := []ArmReport{}
+	for _, arm := range experiment.Arms {
+		reports = append(reports, ArmReport{
+			Arm:      arm.Name,
+			Receipts: getCounter(kv, fmt.Sprintf("experiment-%s-%s-receipts", name, arm.Name)),
+			Points:   getCounter(kv, fmt.Sprintf("experiment-%s-%s-points", name, arm.Name)),
+		})
+	}
+	return reports, nil
+}
+
+// getCounter reads an integer counter, treating a missing key as zero.
+func getCounter(kv *kvstore.KVStore, key string) int {
+	val, err := kv.Get(key)
+	if err != nil {
+		return 0
+	}
+	count, err := strconv.Atoi(val)
+	if err != nil {
+		fmt.Printf("Error reading counter %s: %v\n", key, err)
+		return 0
+	}
+	return count
+}
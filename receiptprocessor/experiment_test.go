@@ -0,0 +1,178 @@
+package receiptprocessor
+
+import (
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func TestExperiments(t *testing.T) {
+	resetStore()
+	defer SetExperiment(nil)
+
+	t.Run("RejectsUnknownRule", func(t *testing.T) {
+		err := SetExperiment(&Experiment{
+			Name: "bad-rules",
+			Arms: []ExperimentArm{{Name: "control", Weight: 1, Rules: []string{"not-a-rule"}}},
+		})
+		if err == nil {
+			t.Errorf("expected an error for an unknown rule")
+		}
+	})
+
+	t.Run("RejectsZeroWeight", func(t *testing.T) {
+		err := SetExperiment(&Experiment{
+			Name: "bad-weight",
+			Arms: []ExperimentArm{{Name: "control", Weight: 0, Rules: []string{"item-pairs"}}},
+		})
+		if err == nil {
+			t.Errorf("expected an error for a zero weight arm")
+		}
+	})
+
+	t.Run("AssignmentIsDeterministic", func(t *testing.T) {
+		experiment := &Experiment{
+			Name: "split",
+			Arms: []ExperimentArm{
+				{Name: "control", Weight: 1, Rules: []string{"item-pairs"}},
+				{Name: "promo", Weight: 1, Rules: []string{"item-pairs", "odd-purchase-day"}},
+			},
+		}
+		if err := SetExperiment(experiment); err != nil {
+			t.Fatalf("could not set experiment: %v", err)
+		}
+		seen := map[string]bool{}
+		for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			_, first := assignExperimentArm(id, "")
+			_, second := assignExperimentArm(id, "")
+			if first.Name != second.Name {
+				t.Errorf("expected %s to land in the same arm twice, got %s and %s", id, first.Name, second.Name)
+			}
+			seen[first.Name] = true
+		}
+		if len(seen) != 2 {
+			t.Errorf("expected receipts in both arms, got %v", seen)
+		}
+	})
+
+	t.Run("AssignByUser", func(t *testing.T) {
+		experiment := &Experiment{
+			Name:     "by-user",
+			AssignBy: AssignByUser,
+			Arms: []ExperimentArm{
+				{Name: "control", Weight: 1, Rules: []string{"item-pairs"}},
+				{Name: "promo", Weight: 1, Rules: []string{"item-pairs"}},
+			},
+		}
+		if err := SetExperiment(experiment); err != nil {
+			t.Fatalf("could not set experiment: %v", err)
+		}
+		_, first := assignExperimentArm("receipt-1", "user-1")
+		for _, id := range []string{"receipt-2", "receipt-3", "receipt-4", "receipt-5"} {
+			if _, arm := assignExperimentArm(id, "user-1"); arm.Name != first.Name {
+				t.Errorf("expected every receipt for user-1 in %s, got %s", first.Name, arm.Name)
+			}
+		}
+	})
+
+	t.Run("ReportAggregatesPoints", func(t *testing.T) {
+		experiment := &Experiment{
+			Name: "report",
+			Arms: []ExperimentArm{{Name: "only", Weight: 1, Rules: []string{"item-pairs"}}},
+		}
+		if err := SetExperiment(experiment); err != nil {
+			t.Fatalf("could not set experiment: %v", err)
+		}
+		receipt := &pb.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: "13:01",
+			Total:        "4.00",
+			Items: []*pb.Item{
+				{ShortDescription: "Gum", Price: "1.00"},
+				{ShortDescription: "Gum", Price: "1.00"},
+				{ShortDescription: "Gum", Price: "1.00"},
+				{ShortDescription: "Gum", Price: "1.00"},
+			},
+		}
+		id, err := ProcessReceipt(receipt)
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		// a second purchase on another day, so it isn't treated as a duplicate
+		receipt.PurchaseDate = "2022-01-04"
+		if _, err := ProcessReceipt(receipt); err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+
+		arm, err := GetReceiptArm(id)
+		if err != nil || arm != "report/only" {
+			t.Errorf("expected receipt arm report/only, got %q (%v)", arm, err)
+		}
+
+		reports, err := ExperimentReport("report")
+		if err != nil {
+			t.Fatalf("could not get report: %v", err)
+		}
+		if len(reports) != 1 || reports[0].Receipts != 2 || reports[0].Points != 20 {
+			t.Errorf("expected 2 receipts and 20 points, got %+v", reports)
+		}
+
+		if _, err := ExperimentReport("missing"); err == nil {
+			t.Errorf("expected an error for an experiment that is not running")
+		}
+	})
+
+	t.Run("RescoreCountsOnce", func(t *testing.T) {
+		experiment := &Experiment{
+			Name: "rescore",
+			Arms: []ExperimentArm{{Name: "only", Weight: 1, Rules: []string{"item-pairs"}}},
+		}
+		if err := SetExperiment(experiment); err != nil {
+			t.Fatalf("could not set experiment: %v", err)
+		}
+		receipt := &pb.Receipt{
+			Retailer:     "Rescore Records",
+			PurchaseDate: "2022-01-06",
+			PurchaseTime: "13:01",
+			Total:        "2.00",
+			Items: []*pb.Item{
+				{ShortDescription: "Tape", Price: "1.00"},
+				{ShortDescription: "Tape", Price: "1.00"},
+			},
+		}
+		id, err := ProcessReceipt(receipt)
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		before, _ := ExperimentReport("rescore")
+
+		if err := processReceipt(id, "", receipt); err != nil {
+			t.Fatalf("could not score receipt again: %v", err)
+		}
+		after, _ := ExperimentReport("rescore")
+		if after[0] != before[0] {
+			t.Errorf("expected scoring the receipt again to change nothing, got %+v then %+v", before, after)
+		}
+
+		corrected := &pb.Receipt{
+			Retailer:     receipt.Retailer,
+			PurchaseDate: receipt.PurchaseDate,
+			PurchaseTime: receipt.PurchaseTime,
+			Total:        "4.00",
+			Items: []*pb.Item{
+				{ShortDescription: "Tape", Price: "1.00"},
+				{ShortDescription: "Tape", Price: "1.00"},
+				{ShortDescription: "Tape", Price: "1.00"},
+				{ShortDescription: "Tape", Price: "1.00"},
+			},
+		}
+		if _, err := CorrectReceipt(id, corrected, "missed two items"); err != nil {
+			t.Fatalf("could not correct receipt: %v", err)
+		}
+		after, _ = ExperimentReport("rescore")
+		if after[0].Receipts != before[0].Receipts || after[0].Points != before[0].Points+5 {
+			t.Errorf("expected the correction to add a pair's 5 points to the arm, got %+v then %+v", before, after)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// Points expire in the order they were awarded. Every entry that puts points into an account starts
+// a lot that expires according to the policy, and every entry that takes points out, whether a
+// redemption, a void or an earlier expiry, uses up the oldest lots first. Voids and corrections first
+// take back what is left of their own receipt's lot. ExpirePoints moves what is
+// left of the expired lots to AccountPointsExpired, so they stop counting towards the liability.
+
+// AccountPointsExpired is credited with the points that expire unspent.
+const AccountPointsExpired = "points-expired"
+
+// EntryExpiry is the kind of ledger entry posted when points expire.
+const EntryExpiry = "expiry"
+
+// Expiry rules.
+const (
+	// ExpireNever keeps points until they are spent.
+	ExpireNever = "never"
+	// ExpireAfterMonths expires points a number of months after they were awarded.
+	ExpireAfterMonths = "months"
+	// ExpireEndOfNextYear expires points at the end of the calendar year after the one they were awarded in.
+	ExpireEndOfNextYear = "end-of-next-year"
+)
+
+// ExpiryPolicy decides when awarded points expire.
+type ExpiryPolicy struct {
+	// Rule is ExpireNever, ExpireAfterMonths or ExpireEndOfNextYear.
+	Rule string `json:"rule"`
+	// Months is how long points last under ExpireAfterMonths.
+	Months int `json:"months"`
+}
+
+// DefaultExpiryPolicy never expires points.
+var DefaultExpiryPolicy = ExpiryPolicy{Rule: ExpireNever}
+
+var (
+	expiryMu     sync.RWMutex
+	expiryPolicy = DefaultExpiryPolicy
+)
+
+// SetExpiryPolicy validates and activates an expiry policy. It applies to points already awarded,
+// so they expire on the new schedule at the next sweep.
+func SetExpiryPolicy(policy ExpiryPolicy) error {
+	switch policy.Rule {
+	case ExpireNever, ExpireEndOfNextYear:
+	case ExpireAfterMonths:
+		if policy.Months < 1 {
+			return fmt.Errorf("expiry months must be at least 1")
+		}
+	default:
+		return fmt.Errorf("unknown expiry rule %q", policy.Rule)
+	}
+	expiryMu.Lock()
+	defer expiryMu.Unlock()
+	expiryPolicy = policy
+	return nil
+}
+
+// expiresAt returns when points awarded at a time expire, and false if they never do.
+func (p ExpiryPolicy) expiresAt(awardedAt time.Time) (time.Time, bool) {
+	switch p.Rule {
+	case ExpireAfterMonths:
+		return awardedAt.AddDate(0, p.Months, 0), true
+	case ExpireEndOfNextYear:
+		return time.Date(awardedAt.Year()+2, time.January, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// pointsLot is what is left of the points put into an account by one entry.
+type pointsLot struct {
+	points    int
+	receiptID string
+	awardedAt time.Time
+	expiresAt time.Time
+	expires   bool
+}
+
+// openLots replays an account and returns the lots it still holds, oldest first.
+func openLots(account string, policy ExpiryPolicy) []pointsLot {
+	lots, _ := replayLots(account, policy)
+	return lots
+}
+
+// replayLots replays an account, returning the lots it still holds, oldest first, and the points
+// of each receipt that expired.
+func replayLots(account string, policy ExpiryPolicy) ([]pointsLot, map[string]int) {
+	lots := []pointsLot{}
+	expired := map[string]int{}
+	for _, entry := range accountLedger(account) {
+		if entry.Credit == account {
+			awardedAt, err := time.Parse(time.RFC3339Nano, entry.At)
+			if err != nil {
+				continue
+			}
+			lot := pointsLot{points: int(entry.Amount), receiptID: entry.ReceiptId, awardedAt: awardedAt}
+			lot.expiresAt, lot.expires = policy.expiresAt(awardedAt)
+			lots = append(lots, lot)
+			continue
+		}
+		taken := int(entry.Amount)
+		if (entry.Kind == EntryVoid || entry.Kind == EntryCorrection) && entry.ReceiptId != "" {
+			open := lots[:0]
+			for _, lot := range lots {
+				if lot.receiptID == entry.ReceiptId {
+					used := min(taken, lot.points)
+					lot.points -= used
+					taken -= used
+				}
+				if lot.points > 0 {
+					open = append(open, lot)
+				}
+			}
+			lots = open
+		}
+		for taken > 0 && len(lots) > 0 {
+			used := min(taken, lots[0].points)
+			if entry.Kind == EntryExpiry && lots[0].receiptID != "" {
+				expired[lots[0].receiptID] += used
+			}
+			lots[0].points -= used
+			taken -= used
+			if lots[0].points == 0 {
+				lots = lots[1:]
+			}
+		}
+	}
+	return lots, expired
+}
+
+// ExpirePoints posts an expiry entry for every account holding points that expired by a time,
+// and returns how many points expired.
+func ExpirePoints(at time.Time) int {
+	expiryMu.RLock()
+	policy := expiryPolicy
+	expiryMu.RUnlock()
+	if policy.Rule == ExpireNever {
+		return 0
+	}
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+
+	kv := kvstore.New()
+	expired := 0
+	for _, key := range kv.Keys("ledger-balance-") {
+		account := strings.TrimPrefix(key, "ledger-balance-")
+		if !isHolderAccount(account) {
+			continue
+		}
+		points := 0
+		var latest time.Time
+		for _, lot := range openLots(account, policy) {
+			if !lot.expires || lot.expiresAt.After(at) {
+				break
+			}
+			points += lot.points
+			latest = lot.awardedAt
+		}
+		if points == 0 {
+			continue
+		}
+		entry := &pb.LedgerEntry{
+			Kind:   EntryExpiry,
+			Debit:  account,
+			Credit: AccountPointsExpired,
+			Amount: int32(points),
+			Reason: fmt.Sprintf("points awarded up to %s expired", latest.UTC().Format(time.RFC3339)),
+		}
+		if account != AccountAnonymous {
+			entry.UserId = strings.TrimPrefix(account, "user:")
+		}
+		post(entry)
+		expired += points
+	}
+	return expired
+}
+
+// voidPoints takes back the points of a receipt being voided. Points that already expired are taken
+// back from AccountPointsExpired, and the rest from the holder, but never more than the holder has
+// left, so a void can't leave a negative balance. Any points spent beyond that stay with the receipt.
+func voidPoints(id string, reason string) {
+	expiryMu.RLock()
+	policy := expiryPolicy
+	expiryMu.RUnlock()
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+
+	userID := receiptUser(id)
+	account := userAccount(userID)
+	points := storedPoints(id)
+	_, expired := replayLots(account, policy)
+	if fromExpired := min(expired[id], points); fromExpired > 0 {
+		post(&pb.LedgerEntry{
+			Kind:      EntryVoid,
+			Debit:     AccountPointsExpired,
+			Credit:    AccountPointsIssued,
+			Amount:    int32(fromExpired),
+			Reason:    reason,
+			ReceiptId: id,
+			UserId:    userID,
+		})
+		points -= fromExpired
+		setReceiptPoints(id, points)
+		if userID != "" {
+			refreshTier(userID, now())
+		}
+	}
+	postReceiptPoints(id, EntryVoid, -takeablePoints(account, points), reason, "")
+}
+
+// ExpiringPoints returns the points of a user that expire before a time, including any that have
+// expired but not been swept yet.
+func ExpiringPoints(userID string, before time.Time) *pb.ExpiringPoints {
+	expiryMu.RLock()
+	policy := expiryPolicy
+	expiryMu.RUnlock()
+
+	expiring := &pb.ExpiringPoints{
+		UserId: userID,
+		Before: before.UTC().Format(time.RFC3339),
+		Lots:   []*pb.ExpiringLot{},
+	}
+	for _, lot := range openLots(userAccount(userID), policy) {
+		if !lot.expires || !lot.expiresAt.Before(before) {
+			break
+		}
+		expiring.Points += int32(lot.points)
+		expiring.Lots = append(expiring.Lots, &pb.ExpiringLot{
+			Points:    int32(lot.points),
+			AwardedAt: lot.awardedAt.UTC().Format(time.RFC3339Nano),
+			ExpiresAt: lot.expiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return expiring
+}
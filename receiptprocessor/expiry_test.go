@@ -0,0 +1,138 @@
+package receiptprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func TestExpirePoints(t *testing.T) {
+	resetStore()
+	awardedAt := time.Date(2023, 3, 10, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return awardedAt }
+	old, err := ProcessReceiptForUser("expiry-user", fraudReceipt("Expiry Eats", "08:00", "6.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	now = func() time.Time { return awardedAt.AddDate(0, 6, 0) }
+	recent, err := ProcessReceiptForUser("expiry-user", fraudReceipt("Expiry Espresso", "08:30", "2.10"))
+	now = time.Now
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	oldPoints, recentPoints := storedPoints(old), storedPoints(recent)
+
+	if err := SetExpiryPolicy(ExpiryPolicy{Rule: ExpireAfterMonths, Months: 12}); err != nil {
+		t.Fatalf("could not set expiry policy: %v", err)
+	}
+	defer SetExpiryPolicy(DefaultExpiryPolicy)
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := SetExpiryPolicy(ExpiryPolicy{Rule: ExpireAfterMonths}); err == nil {
+			t.Error("expected an error without months")
+		}
+		if err := SetExpiryPolicy(ExpiryPolicy{Rule: "sometimes"}); err == nil {
+			t.Error("expected an error for an unknown rule")
+		}
+	})
+
+	t.Run("Expiring", func(t *testing.T) {
+		expiring := ExpiringPoints("expiry-user", awardedAt.AddDate(1, 1, 0))
+		if int(expiring.Points) != oldPoints || len(expiring.Lots) != 1 || expiring.Lots[0].ExpiresAt != "2024-03-10T12:00:00Z" {
+			t.Errorf("expected the first receipt's %d points to expire on 2024-03-10, got %v", oldPoints, expiring)
+		}
+	})
+
+	t.Run("SpentFirst", func(t *testing.T) {
+		if _, err := AddReward(&pb.Reward{Id: "expiry-pen", Name: "Pen", Cost: 1, Inventory: 1}); err != nil {
+			t.Fatalf("could not add reward: %v", err)
+		}
+		if _, err := Redeem("expiry-user", "expiry-pen"); err != nil {
+			t.Fatalf("could not redeem reward: %v", err)
+		}
+		oldPoints--
+		if expiring := ExpiringPoints("expiry-user", awardedAt.AddDate(1, 1, 0)); int(expiring.Points) != oldPoints {
+			t.Errorf("expected the redemption to use the oldest points, leaving %d, got %v", oldPoints, expiring)
+		}
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		ExpirePoints(awardedAt.AddDate(1, 0, 1))
+		if balance := AccountBalance(userAccount("expiry-user")); balance != recentPoints {
+			t.Errorf("expected %d points left after the sweep, got %d", recentPoints, balance)
+		}
+		if expiring := ExpiringPoints("expiry-user", awardedAt.AddDate(1, 1, 0)); expiring.Points != 0 {
+			t.Errorf("expected nothing left to expire, got %v", expiring)
+		}
+		ExpirePoints(awardedAt.AddDate(1, 0, 1))
+		if balance := AccountBalance(userAccount("expiry-user")); balance != recentPoints {
+			t.Errorf("expected a second sweep to change nothing, got %d", balance)
+		}
+		if report := Reconcile(); len(report.Mismatches) > 0 {
+			t.Errorf("expected the ledger to reconcile, got %v", report.Mismatches)
+		}
+	})
+
+	t.Run("VoidAfterExpiry", func(t *testing.T) {
+		expiredBefore := AccountBalance(AccountPointsExpired)
+		if _, err := VoidReceipt(old, "refunded"); err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		// the expired points come back from points-expired, and the one point spent from what is left
+		if balance := AccountBalance(userAccount("expiry-user")); balance != recentPoints-1 {
+			t.Errorf("expected %d points left after the void, got %d", recentPoints-1, balance)
+		}
+		if expired := expiredBefore - AccountBalance(AccountPointsExpired); expired != oldPoints {
+			t.Errorf("expected %d expired points to be taken back, got %d", oldPoints, expired)
+		}
+		if points := storedPoints(old); points != 0 {
+			t.Errorf("expected the voided receipt to have no points, got %d", points)
+		}
+		if report := Reconcile(); len(report.Mismatches) > 0 {
+			t.Errorf("expected the ledger to reconcile, got %v", report.Mismatches)
+		}
+	})
+
+	t.Run("EndOfNextYear", func(t *testing.T) {
+		policy := ExpiryPolicy{Rule: ExpireEndOfNextYear}
+		if at, _ := policy.expiresAt(awardedAt); !at.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected points awarded in 2023 to expire at the end of 2024, got %v", at)
+		}
+	})
+}
+
+func TestVoidBeforeExpiry(t *testing.T) {
+	resetStore()
+	awardedAt := time.Date(2023, 5, 10, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return awardedAt }
+	defer func() { now = time.Now }()
+	if _, err := ProcessReceiptForUser("expiry-voider", fraudReceipt("Expiry Easels", "08:00", "6.10")); err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	now = func() time.Time { return awardedAt.AddDate(0, 6, 0) }
+	newer, err := ProcessReceiptForUser("expiry-voider", fraudReceipt("Expiry Envelopes", "08:30", "2.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+
+	if err := SetExpiryPolicy(ExpiryPolicy{Rule: ExpireAfterMonths, Months: 12}); err != nil {
+		t.Fatalf("could not set expiry policy: %v", err)
+	}
+	defer SetExpiryPolicy(DefaultExpiryPolicy)
+	if _, err := VoidReceipt(newer, "refunded"); err != nil {
+		t.Fatalf("could not void receipt: %v", err)
+	}
+
+	// the void takes back the newer receipt's own points, so the older ones all expire on time
+	ExpirePoints(awardedAt.AddDate(1, 0, 1))
+	if balance := AccountBalance(userAccount("expiry-voider")); balance != 0 {
+		t.Errorf("expected nothing left after the older points expired, got %d", balance)
+	}
+	if expiring := ExpiringPoints("expiry-voider", awardedAt.AddDate(3, 0, 0)); expiring.Points != 0 {
+		t.Errorf("expected none of the voided receipt's points left to expire, got %v", expiring)
+	}
+	if report := Reconcile(); len(mismatchesFor(report, "expiry-voider")) > 0 {
+		t.Errorf("expected the ledger to reconcile, got %v", report.Mismatches)
+	}
+}
@@ -0,0 +1,115 @@
+package receiptprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// DuplicatesReturnOriginal answers a resubmitted receipt with the ID of the original, without scoring it again.
+	DuplicatesReturnOriginal = "return-original"
+	// DuplicatesReject refuses a resubmitted receipt with ErrDuplicateReceipt.
+	DuplicatesReject = "reject"
+)
+
+// ErrDuplicateReceipt is returned when a receipt with the same fingerprint was already submitted
+// and the duplicate policy is DuplicatesReject.
+var ErrDuplicateReceipt = errors.New("receipt has already been submitted")
+
+var (
+	duplicatePolicyMu sync.RWMutex
+	duplicatePolicy   = DuplicatesReturnOriginal
+)
+
+// SetDuplicatePolicy chooses how resubmitted receipts are handled. An empty policy restores the default.
+func SetDuplicatePolicy(policy string) error {
+	switch policy {
+	case "":
+		policy = DuplicatesReturnOriginal
+	case DuplicatesReturnOriginal, DuplicatesReject:
+	default:
+		return fmt.Errorf("unknown duplicate policy %q", policy)
+	}
+	duplicatePolicyMu.Lock()
+	duplicatePolicy = policy
+	duplicatePolicyMu.Unlock()
+	return nil
+}
+
+func currentDuplicatePolicy() string {
+	duplicatePolicyMu.RLock()
+	defer duplicatePolicyMu.RUnlock()
+	return duplicatePolicy
+}
+
+// Fingerprint identifies the purchase a receipt records, so the same receipt uploaded twice
+// matches even if whitespace, letter case, amount formatting or item order differ.
+func Fingerprint(receipt *pb.Receipt) string {
+	currency, err := receiptCurrency(receipt.Currency)
+	if err != nil {
+		currency = strings.ToUpper(strings.TrimSpace(receipt.Currency))
+	}
+
+	items := make([]string, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items = append(items, canonicalName(item.ShortDescription)+"|"+canonicalAmount(item.Price, currency))
+	}
+	sort.Strings(items)
+
+	lines := []string{
+		canonicalName(receipt.Retailer),
+		strings.TrimSpace(receipt.PurchaseDate),
+		strings.TrimSpace(receipt.PurchaseTime),
+		currency,
+		canonicalAmount(receipt.Total, currency),
+	}
+	lines = append(lines, items...)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalName folds case and collapses runs of whitespace.
+func canonicalName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(norm.NFC.String(name))), " ")
+}
+
+// canonicalAmount writes an amount in minor units, so "09.00" and "9.00" match.
+// An amount that doesn't parse is used as written.
+func canonicalAmount(amount string, currency string) string {
+	amount = strings.TrimSpace(amount)
+	places, ok := minorUnits[currency]
+	if !ok {
+		return amount
+	}
+	value, err := parseMinorUnits(amount, places)
+	if err != nil {
+		return amount
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// claimFingerprint records id as the receipt with this fingerprint. If another receipt
+// already holds the fingerprint, its ID is returned with false.
+func claimFingerprint(fingerprint string, id string) (string, bool) {
+	kv := kvstore.New()
+	return kv.SetIfAbsent(fmt.Sprintf("fingerprint-%s", fingerprint), id)
+}
+
+// releaseFingerprint frees a fingerprint held by id, so the same receipt can be submitted again.
+func releaseFingerprint(fingerprint string, id string) {
+	kv := kvstore.New()
+	key := fmt.Sprintf("fingerprint-%s", fingerprint)
+	if holder, err := kv.Get(key); err == nil && holder == id {
+		kv.Delete(key)
+	}
+}
@@ -0,0 +1,91 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func fingerprintReceipt() *pb.Receipt {
+	return &pb.Receipt{
+		Retailer:     "Walgreens",
+		PurchaseDate: "2022-01-03",
+		PurchaseTime: "08:13",
+		Total:        "2.65",
+		Items: []*pb.Item{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+			{ShortDescription: "Dasani", Price: "1.40"},
+		},
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Run("IgnoresFormatting", func(t *testing.T) {
+		reformatted := fingerprintReceipt()
+		reformatted.Retailer = "  WALGREENS "
+		reformatted.Currency = "usd"
+		reformatted.Items = []*pb.Item{
+			{ShortDescription: "dasani", Price: "1.40"},
+			{ShortDescription: "Pepsi  -  12-oz", Price: "1.25"},
+		}
+		if Fingerprint(fingerprintReceipt()) != Fingerprint(reformatted) {
+			t.Errorf("expected the reformatted receipt to have the same fingerprint")
+		}
+	})
+
+	t.Run("DistinguishesPurchases", func(t *testing.T) {
+		original := Fingerprint(fingerprintReceipt())
+		changes := map[string]func(*pb.Receipt){
+			"retailer": func(r *pb.Receipt) { r.Retailer = "Walmart" },
+			"date":     func(r *pb.Receipt) { r.PurchaseDate = "2022-01-04" },
+			"time":     func(r *pb.Receipt) { r.PurchaseTime = "08:14" },
+			"total":    func(r *pb.Receipt) { r.Total = "2.66" },
+			"item":     func(r *pb.Receipt) { r.Items[0].Price = "1.26" },
+			"items":    func(r *pb.Receipt) { r.Items = r.Items[:1] },
+		}
+		for name, change := range changes {
+			receipt := fingerprintReceipt()
+			change(receipt)
+			if Fingerprint(receipt) == original {
+				t.Errorf("expected a different %s to change the fingerprint", name)
+			}
+		}
+	})
+}
+
+func TestDuplicateReceipts(t *testing.T) {
+	defer SetDuplicatePolicy(DuplicatesReturnOriginal)
+
+	receipt := fingerprintReceipt()
+	receipt.Retailer = "Duplicate Drugstore"
+	id, err := ProcessReceipt(receipt)
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+
+	t.Run("ReturnOriginal", func(t *testing.T) {
+		again := fingerprintReceipt()
+		again.Retailer = "duplicate drugstore"
+		duplicate, err := ProcessReceipt(again)
+		if err != nil || duplicate != id {
+			t.Errorf("expected the original ID %s, got %s (%v)", id, duplicate, err)
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		if err := SetDuplicatePolicy(DuplicatesReject); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		duplicate, err := ProcessReceipt(receipt)
+		if !errors.Is(err, ErrDuplicateReceipt) || duplicate != id {
+			t.Errorf("expected ErrDuplicateReceipt for %s, got %s (%v)", id, duplicate, err)
+		}
+	})
+
+	t.Run("RejectsUnknownPolicy", func(t *testing.T) {
+		if err := SetDuplicatePolicy("ignore"); err == nil {
+			t.Errorf("expected an unknown policy to be rejected")
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package receiptprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Names of the fraud signals, as reported in a RiskAssessment.
+const (
+	SignalNearDuplicate      = "near-duplicate"
+	SignalRoundTotals        = "round-totals"
+	SignalPaddedDescriptions = "padded-descriptions"
+	SignalSubmissionRate     = "submission-rate"
+)
+
+// signalScores is how much each signal adds to the risk score, out of 100.
+var signalScores = map[string]int{
+	SignalNearDuplicate:      60,
+	SignalRoundTotals:        30,
+	SignalPaddedDescriptions: 30,
+	SignalSubmissionRate:     40,
+}
+
+// FraudPolicy configures the fraud signals and the risk score at which a receipt is held for review.
+type FraudPolicy struct {
+	// HoldScore holds receipts with at least this risk score instead of scoring them. 0 never holds.
+	HoldScore int `json:"holdScore"`
+	// NearDuplicateMinutes flags a receipt from the same user and retailer with the same total purchased within this many minutes of another.
+	// Anonymous receipts aren't compared, since unrelated customers often buy the same thing.
+	NearDuplicateMinutes int `json:"nearDuplicateMinutes"`
+	// RoundTotalsMinReceipts is how many receipts a user must submit before their share of round-dollar totals is judged.
+	RoundTotalsMinReceipts int `json:"roundTotalsMinReceipts"`
+	// RoundTotalsPercent flags a round-dollar receipt from a user whose receipts are at least this percent round dollars.
+	RoundTotalsPercent int `json:"roundTotalsPercent"`
+	// MaxSubmissionsPerHour flags a user's receipts once they submit more than this many in an hour.
+	MaxSubmissionsPerHour int `json:"maxSubmissionsPerHour"`
+}
+
+// DefaultFraudPolicy holds near-duplicates, and receipts that trip two of the weaker signals.
+var DefaultFraudPolicy = FraudPolicy{
+	HoldScore:              60,
+	NearDuplicateMinutes:   10,
+	RoundTotalsMinReceipts: 5,
+	RoundTotalsPercent:     90,
+	MaxSubmissionsPerHour:  20,
+}
+
+var (
+	fraudMu     sync.RWMutex
+	fraudPolicy = DefaultFraudPolicy
+)
+
+// SetFraudPolicy validates and activates a fraud policy.
+func SetFraudPolicy(policy FraudPolicy) error {
+	if policy.HoldScore < 0 || policy.NearDuplicateMinutes < 0 || policy.RoundTotalsMinReceipts < 0 || policy.MaxSubmissionsPerHour < 0 {
+		return fmt.Errorf("fraud policy values cannot be negative")
+	}
+	if policy.RoundTotalsPercent < 0 || policy.RoundTotalsPercent > 100 {
+		return fmt.Errorf("fraud roundTotalsPercent must be between 0 and 100")
+	}
+
+	fraudMu.Lock()
+	fraudPolicy = policy
+	fraudMu.Unlock()
+	return nil
+}
+
+func currentFraudPolicy() FraudPolicy {
+	fraudMu.RLock()
+	defer fraudMu.RUnlock()
+	return fraudPolicy
+}
+
+// assessRisk runs every fraud signal against a receipt and records it in the signals' history,
+// so later receipts are judged against it. A signal set to 0 in the policy is off.
+func assessRisk(userID string, receipt *pb.Receipt) *pb.RiskAssessment {
+	policy := currentFraudPolicy()
+	kv := kvstore.New()
+	risk := &pb.RiskAssessment{}
+	flag := func(name string, format string, args ...any) {
+		risk.Signals = append(risk.Signals, &pb.FraudSignal{
+			Name:   name,
+			Score:  int32(signalScores[name]),
+			Detail: fmt.Sprintf(format, args...),
+		})
+		risk.Score = min(risk.Score+int32(signalScores[name]), 100)
+	}
+
+	parsed, err := parseReceipt(receipt)
+	if err == nil {
+		if userID != "" && policy.NearDuplicateMinutes > 0 && seenNearby(kv, userID, parsed, policy.NearDuplicateMinutes) {
+			flag(SignalNearDuplicate, "another receipt from %s for %s was purchased within %d minutes", parsed.Retailer, parsed.Total, policy.NearDuplicateMinutes)
+		}
+		if padded := paddedDescriptions(parsed); len(padded) > 0 {
+			flag(SignalPaddedDescriptions, "descriptions are padded to a multiple of 3 characters: %s", strings.Join(padded, ", "))
+		}
+		if userID != "" && policy.RoundTotalsMinReceipts > 0 {
+			if percent, ok := roundTotalPercent(kv, userID, parsed, policy.RoundTotalsMinReceipts); ok && percent >= policy.RoundTotalsPercent {
+				flag(SignalRoundTotals, "%d%% of the user's receipts have round-dollar totals", percent)
+			}
+		}
+	}
+
+	if userID != "" && policy.MaxSubmissionsPerHour > 0 {
+		hour := now().Unix() / 3600
+		if count, err := kv.Increment(fmt.Sprintf("fraud-rate-%s-%d", userID, hour), 1); err == nil && count > policy.MaxSubmissionsPerHour {
+			flag(SignalSubmissionRate, "%d receipts submitted in the last hour", count)
+		}
+	}
+
+	risk.Held = policy.HoldScore > 0 && int(risk.Score) >= policy.HoldScore
+	return risk
+}
+
+// nearbyMu keeps the purchase times recorded in a near-duplicate bucket from being overwritten.
+var nearbyMu sync.Mutex
+
+// seenNearby records the receipt's purchase time in a bucket for its user, retailer and total, and
+// reports whether a receipt in its own or a neighboring bucket was purchased within the window.
+// Buckets are as wide as the window, so the neighbors hold every purchase close enough to compare.
+func seenNearby(kv *kvstore.KVStore, userID string, receipt *ParsedReceipt, minutes int) bool {
+	sum := sha256.Sum256([]byte(userID + "|" + canonicalName(receipt.Retailer) + "|" + receipt.Total.String()))
+	key := hex.EncodeToString(sum[:8])
+
+	purchased := receipt.PurchaseDate.Add(time.Duration(receipt.PurchaseTime.Hour())*time.Hour + time.Duration(receipt.PurchaseTime.Minute())*time.Minute).Unix()
+	window := int64(minutes * 60)
+	bucket := purchased / window
+
+	nearbyMu.Lock()
+	defer nearbyMu.Unlock()
+	seen := false
+	for _, neighbor := range []int64{bucket - 1, bucket, bucket + 1} {
+		times, err := kv.Get(fmt.Sprintf("fraud-nearby-%s-%d", key, neighbor))
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Split(times, ",") {
+			other, err := strconv.ParseInt(field, 10, 64)
+			if err == nil && other >= purchased-window && other <= purchased+window {
+				seen = true
+			}
+		}
+	}
+	bucketKey := fmt.Sprintf("fraud-nearby-%s-%d", key, bucket)
+	times, err := kv.Get(bucketKey)
+	if err != nil {
+		times = strconv.FormatInt(purchased, 10)
+	} else {
+		times += "," + strconv.FormatInt(purchased, 10)
+	}
+	kv.Set(bucketKey, times)
+	return seen
+}
+
+// paddedDescriptions lists the descriptions that only earn the item-description-length points
+// because of extra spaces or trailing dashes and underscores.
+func paddedDescriptions(receipt *ParsedReceipt) []string {
+	padded := []string{}
+	for _, item := range receipt.Items {
+		trimmed := strings.TrimSpace(item.ShortDescription)
+		if utf8.RuneCountInString(trimmed)%3 != 0 {
+			continue
+		}
+		unpadded := strings.Join(strings.Fields(strings.TrimRight(trimmed, "-_ ")), " ")
+		if utf8.RuneCountInString(unpadded)%3 != 0 {
+			padded = append(padded, fmt.Sprintf("%q", item.ShortDescription))
+		}
+	}
+	return padded
+}
+
+// roundTotalPercent counts the receipt towards the user's totals. If it is a round-dollar total and
+// the user has submitted enough receipts, it returns the percentage of them with round-dollar totals.
+func roundTotalPercent(kv *kvstore.KVStore, userID string, receipt *ParsedReceipt, minReceipts int) (int, bool) {
+	receipts, err := kv.Increment(fmt.Sprintf("fraud-user-%s-receipts", userID), 1)
+	if err != nil {
+		return 0, false
+	}
+	roundKey := fmt.Sprintf("fraud-user-%s-round", userID)
+	if !receipt.Total.IsWholeDollar() {
+		return 0, false
+	}
+	round, err := kv.Increment(roundKey, 1)
+	if err != nil || receipts < minReceipts {
+		return 0, false
+	}
+	return round * 100 / receipts, true
+}
+
+func storeRiskAssessment(id string, risk *pb.RiskAssessment) {
+	kv := kvstore.New()
+	if data, err := protojson.Marshal(risk); err == nil {
+		kv.Set(fmt.Sprintf("receipt-%s-risk", id), string(data))
+	}
+}
+
+// GetRiskAssessment returns the fraud signals and risk score recorded for a receipt.
+func GetRiskAssessment(id string) (*pb.RiskAssessment, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(fmt.Sprintf("receipt-%s-risk", id))
+	if err != nil {
+		return nil, err
+	}
+	risk := &pb.RiskAssessment{}
+	if err := protojson.Unmarshal([]byte(data), risk); err != nil {
+		return nil, err
+	}
+	return risk, nil
+}
@@ -0,0 +1,161 @@
+package receiptprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func fraudReceipt(retailer string, purchaseTime string, total string) *pb.Receipt {
+	return &pb.Receipt{
+		Retailer:     retailer,
+		PurchaseDate: "2022-05-01",
+		PurchaseTime: purchaseTime,
+		Total:        total,
+		Items:        []*pb.Item{{ShortDescription: "Coffee", Price: total}},
+	}
+}
+
+func hasSignal(risk *pb.RiskAssessment, name string) bool {
+	for _, signal := range risk.Signals {
+		if signal.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFraudSignals(t *testing.T) {
+	resetStore()
+	defer SetFraudPolicy(DefaultFraudPolicy)
+
+	t.Run("NearDuplicate", func(t *testing.T) {
+		if risk := assessRisk("bean-user", fraudReceipt("Bean Counter", "09:00", "3.50")); len(risk.Signals) != 0 {
+			t.Errorf("expected the first receipt to raise no signals, got %v", risk.Signals)
+		}
+		risk := assessRisk("bean-user", fraudReceipt("BEAN COUNTER", "09:04", "3.50"))
+		if !hasSignal(risk, SignalNearDuplicate) || risk.Score != 60 || !risk.Held {
+			t.Errorf("expected a held near-duplicate, got %v", risk)
+		}
+		if risk := assessRisk("bean-user", fraudReceipt("Bean Counter", "09:04", "4.50")); hasSignal(risk, SignalNearDuplicate) {
+			t.Errorf("expected a different total not to be a near-duplicate, got %v", risk)
+		}
+		if risk := assessRisk("bean-user", fraudReceipt("Bean Counter", "11:00", "3.50")); hasSignal(risk, SignalNearDuplicate) {
+			t.Errorf("expected a purchase hours later not to be a near-duplicate, got %v", risk)
+		}
+		// 09:00 and 09:19 fall in neighboring 10 minute buckets, but are further apart than 10 minutes
+		assessRisk("bean-user", fraudReceipt("Span Shop", "09:00", "3.50"))
+		if risk := assessRisk("bean-user", fraudReceipt("Span Shop", "09:19", "3.50")); hasSignal(risk, SignalNearDuplicate) {
+			t.Errorf("expected a purchase 19 minutes later not to be a near-duplicate, got %v", risk)
+		}
+		if risk := assessRisk("bean-user", fraudReceipt("Span Shop", "09:29", "3.50")); !hasSignal(risk, SignalNearDuplicate) {
+			t.Errorf("expected a purchase 10 minutes later to be a near-duplicate, got %v", risk)
+		}
+	})
+
+	t.Run("NearDuplicateAnonymous", func(t *testing.T) {
+		assessRisk("", fraudReceipt("Corner Coffee", "09:01", "4.50"))
+		if risk := assessRisk("", fraudReceipt("Corner Coffee", "09:05", "4.50")); hasSignal(risk, SignalNearDuplicate) || risk.Held {
+			t.Errorf("expected anonymous receipts not to be compared with each other, got %v", risk)
+		}
+	})
+
+	t.Run("NearDuplicateOtherUser", func(t *testing.T) {
+		if risk := assessRisk("alice", fraudReceipt("Starbucks", "08:00", "5.25")); hasSignal(risk, SignalNearDuplicate) {
+			t.Errorf("expected the first receipt to raise no signals, got %v", risk.Signals)
+		}
+		if risk := assessRisk("bob", fraudReceipt("Starbucks", "08:07", "5.25")); hasSignal(risk, SignalNearDuplicate) || risk.Held {
+			t.Errorf("expected another user's purchase not to be a near-duplicate, got %v", risk)
+		}
+		if risk := assessRisk("alice", fraudReceipt("Starbucks", "08:07", "5.25")); !hasSignal(risk, SignalNearDuplicate) {
+			t.Errorf("expected the same user's purchase to be a near-duplicate, got %v", risk)
+		}
+	})
+
+	t.Run("PaddedDescriptions", func(t *testing.T) {
+		receipt := fraudReceipt("Padded Pantry", "10:00", "2.00")
+		receipt.Items = []*pb.Item{
+			{ShortDescription: "Gum  ", Price: "1.00"},
+			{ShortDescription: "Mints ---", Price: "1.00"},
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.00"},
+		}
+		// "Gum  " trims to "Gum", which is already a multiple of 3, so only "Mints ---" is padded
+		risk := assessRisk("", receipt)
+		if !hasSignal(risk, SignalPaddedDescriptions) || risk.Held {
+			t.Errorf("expected a padded-descriptions signal that doesn't hold on its own, got %v", risk)
+		}
+		if padded := paddedDescriptions(mustParse(t, receipt)); len(padded) != 1 || padded[0] != `"Mints ---"` {
+			t.Errorf("expected only Mints --- to be padded, got %v", padded)
+		}
+	})
+
+	t.Run("RoundTotals", func(t *testing.T) {
+		var risk *pb.RiskAssessment
+		for i, purchaseTime := range []string{"08:00", "09:00", "10:00", "11:00", "12:00"} {
+			risk = assessRisk("round-user", fraudReceipt("Round Shop", purchaseTime, "5.00"))
+			if i < 4 && hasSignal(risk, SignalRoundTotals) {
+				t.Errorf("expected no round-totals signal before 5 receipts, got %v", risk)
+			}
+		}
+		if !hasSignal(risk, SignalRoundTotals) {
+			t.Errorf("expected a round-totals signal after 5 round receipts, got %v", risk)
+		}
+		if risk := assessRisk("round-user", fraudReceipt("Round Shop", "13:00", "5.25")); hasSignal(risk, SignalRoundTotals) {
+			t.Errorf("expected a receipt with cents not to be flagged, got %v", risk)
+		}
+	})
+
+	t.Run("SubmissionRate", func(t *testing.T) {
+		defer func() { now = time.Now }()
+		now = func() time.Time { return time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC) }
+		if err := SetFraudPolicy(FraudPolicy{MaxSubmissionsPerHour: 2}); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		var risk *pb.RiskAssessment
+		for _, purchaseTime := range []string{"08:00", "09:00", "10:00"} {
+			risk = assessRisk("busy-user", fraudReceipt("Rate Shop", purchaseTime, "1.25"))
+		}
+		if !hasSignal(risk, SignalSubmissionRate) || risk.Held {
+			t.Errorf("expected a submission-rate signal without a hold score, got %v", risk)
+		}
+	})
+
+	t.Run("HeldReceiptsEarnNoPoints", func(t *testing.T) {
+		if err := SetFraudPolicy(DefaultFraudPolicy); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		if _, err := ProcessReceiptForUser("held-user", fraudReceipt("Held Deli", "12:00", "9.00")); err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		id, err := ProcessReceiptForUser("held-user", fraudReceipt("Held Deli", "12:05", "9.00"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		risk, err := GetRiskAssessment(id)
+		if err != nil || !risk.Held {
+			t.Errorf("expected the receipt to be held, got %v (%v)", risk, err)
+		}
+		if _, err := GetScoreBreakdown(id); err == nil {
+			t.Errorf("expected a held receipt not to be scored")
+		}
+	})
+
+	t.Run("RejectsBadPolicy", func(t *testing.T) {
+		if err := SetFraudPolicy(FraudPolicy{RoundTotalsPercent: 150}); err == nil {
+			t.Errorf("expected a percentage over 100 to be rejected")
+		}
+		if err := SetFraudPolicy(FraudPolicy{HoldScore: -1}); err == nil {
+			t.Errorf("expected a negative hold score to be rejected")
+		}
+	})
+}
+
+func mustParse(t *testing.T, receipt *pb.Receipt) *ParsedReceipt {
+	t.Helper()
+	parsed, err := parseReceipt(receipt)
+	if err != nil {
+		t.Fatalf("could not parse receipt: %v", err)
+	}
+	return parsed
+}
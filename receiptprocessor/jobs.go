@@ -0,0 +1,234 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Scoring jobs are kept in the store under "job-<receipt ID>" until the receipt is scored, so a
+// restart picks them up again. A receipt can be scored more than once if the process stops between
+// scoring it and deleting its job; scoring it again only posts a correction for any difference
+// from the points already in the ledger.
+
+// ErrNotDeadLettered is returned when requeueing a receipt that isn't on the dead-letter list.
+var ErrNotDeadLettered = errors.New("receipt is not on the dead-letter list")
+
+// RetryPolicy configures how scoring failures are retried before a receipt is dead-lettered.
+type RetryPolicy struct {
+	// MaxAttempts is how many times scoring is tried in total.
+	MaxAttempts int `json:"maxAttempts"`
+	// Backoff is the delay before the first retry, doubled for every retry after it, e.g. "1s".
+	Backoff string `json:"backoff"`
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff string `json:"maxBackoff"`
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// DefaultRetryPolicy tries five times, waiting 1s, 2s, 4s and 8s between attempts.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, Backoff: "1s", MaxBackoff: "1m", backoff: time.Second, maxBackoff: time.Minute}
+
+var (
+	retryMu     sync.RWMutex
+	retryPolicy = DefaultRetryPolicy
+	// running stops two deliveries of the same job being scored at once
+	running sync.Map
+)
+
+// SetRetryPolicy validates and activates a retry policy.
+func SetRetryPolicy(policy RetryPolicy) error {
+	if policy.MaxAttempts < 1 {
+		return fmt.Errorf("retry maxAttempts must be at least 1")
+	}
+	for name, value := range map[string]*string{"backoff": &policy.Backoff, "maxBackoff": &policy.MaxBackoff} {
+		if *value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(*value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("retry %s: invalid duration %q", name, *value)
+		}
+		if name == "backoff" {
+			policy.backoff = duration
+		} else {
+			policy.maxBackoff = duration
+		}
+	}
+
+	retryMu.Lock()
+	retryPolicy = policy
+	retryMu.Unlock()
+	return nil
+}
+
+func currentRetryPolicy() RetryPolicy {
+	retryMu.RLock()
+	defer retryMu.RUnlock()
+	return retryPolicy
+}
+
+// delay is how long to wait before the next attempt, after the given number of failures.
+func (p RetryPolicy) delay(failures int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < failures && (p.maxBackoff == 0 || delay < p.maxBackoff); i++ {
+		delay *= 2
+	}
+	if p.maxBackoff > 0 && delay > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return delay
+}
+
+// queueScoring records a scoring job for a receipt, whose slot is already reserved, and dispatches it.
+func queueScoring(id string, userID string, reason string) *pb.ScoringJob {
+	job := &pb.ScoringJob{
+		ReceiptId:  id,
+		UserId:     userID,
+		EnqueuedAt: now().UTC().Format(time.RFC3339Nano),
+	}
+	setStatus(id, StatusProcessing, reason)
+	saveJob(id, job)
+	dispatch(id)
+	return job
+}
+
+// runJob makes one attempt at scoring a receipt. On failure the job is retried after a backoff,
+// or moved to the dead-letter list once it runs out of attempts.
+func runJob(id string) {
+	if _, busy := running.LoadOrStore(id, true); busy {
+		releaseSlot()
+		return
+	}
+	defer running.Delete(id)
+
+	kv := kvstore.New()
+	key := fmt.Sprintf("job-%s", id)
+	job, err := loadJob(key)
+	if err != nil {
+		// already done by an earlier delivery
+		releaseSlot()
+		return
+	}
+	if status, err := GetReceiptStatus(id); err == nil && status.Status == StatusScored {
+		kv.Delete(key)
+		releaseSlot()
+		return
+	}
+
+	err = scoreJob(job)
+	if err == nil {
+		kv.Delete(key)
+		releaseSlot()
+		return
+	}
+
+	policy := currentRetryPolicy()
+	job.Attempts++
+	job.LastError = err.Error()
+	fmt.Printf("Error scoring receipt %s (attempt %d of %d): %v\n", id, job.Attempts, policy.MaxAttempts, err)
+	if int(job.Attempts) >= policy.MaxAttempts {
+		deadLetter(id, job)
+		releaseSlot()
+		return
+	}
+
+	delay := policy.delay(int(job.Attempts))
+	job.NextAttemptAt = now().Add(delay).UTC().Format(time.RFC3339Nano)
+	saveJob(id, job)
+	// the slot stays reserved while the job waits for its retry
+	time.AfterFunc(delay, func() { dispatch(id) })
+}
+
+// scoreJob scores the stored receipt, turning a panic in a rule into an error.
+func scoreJob(job *pb.ScoringJob) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("scoring panicked: %v", recovered)
+		}
+	}()
+	receipt, err := storedReceipt(job.ReceiptId)
+	if err != nil {
+		return fmt.Errorf("loading receipt: %w", err)
+	}
+	return processReceipt(job.ReceiptId, job.UserId, receipt)
+}
+
+// scheduleJob dispatches a job found in the store, waiting out what is left of its backoff.
+func scheduleJob(id string, job *pb.ScoringJob) {
+	if next, err := time.Parse(time.RFC3339Nano, job.NextAttemptAt); err == nil {
+		if wait := next.Sub(now()); wait > 0 {
+			time.AfterFunc(wait, func() { dispatch(id) })
+			return
+		}
+	}
+	dispatch(id)
+}
+
+func deadLetter(id string, job *pb.ScoringJob) {
+	kv := kvstore.New()
+	job.NextAttemptAt = ""
+	if data, err := protojson.Marshal(job); err == nil {
+		kv.Set(fmt.Sprintf("deadletter-%s", id), string(data))
+	}
+	kv.Delete(fmt.Sprintf("job-%s", id))
+	setStatus(id, StatusFailed, fmt.Sprintf("scoring failed %d times: %s", job.Attempts, job.LastError))
+}
+
+// DeadLetters returns the jobs of receipts that ran out of scoring attempts.
+func DeadLetters() []*pb.ScoringJob {
+	kv := kvstore.New()
+	deadLetters := []*pb.ScoringJob{}
+	for _, key := range kv.Keys("deadletter-") {
+		if job, err := loadJob(key); err == nil {
+			deadLetters = append(deadLetters, job)
+		}
+	}
+	return deadLetters
+}
+
+// RequeueDeadLetter takes a receipt off the dead-letter list and gives it a fresh set of attempts.
+func RequeueDeadLetter(id string) (*pb.ScoringJob, error) {
+	if err := reserveSlot(); err != nil {
+		return nil, err
+	}
+	kv := kvstore.New()
+	data, err := kv.Take(fmt.Sprintf("deadletter-%s", id))
+	if err != nil {
+		releaseSlot()
+		return nil, ErrNotDeadLettered
+	}
+	job := &pb.ScoringJob{}
+	if err := protojson.Unmarshal([]byte(data), job); err != nil {
+		releaseSlot()
+		return nil, err
+	}
+
+	return queueScoring(id, job.UserId, "requeued from the dead-letter list"), nil
+}
+
+func saveJob(id string, job *pb.ScoringJob) {
+	kv := kvstore.New()
+	if data, err := protojson.Marshal(job); err == nil {
+		kv.Set(fmt.Sprintf("job-%s", id), string(data))
+	}
+}
+
+func loadJob(key string) (*pb.ScoringJob, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	job := &pb.ScoringJob{}
+	if err := protojson.Unmarshal([]byte(data), job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,134 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// failures is how many more times the test-flaky rule panics before it starts scoring.
+var failures atomic.Int32
+
+func init() {
+	RegisterRule("test-flaky", func() Rule {
+		return NewRule("test-flaky", StageBase, func(receipt *ParsedReceipt, _ int) int {
+			if receipt.Retailer == "Flaky Foods" && failures.Add(-1) >= 0 {
+				panic("flaky rule")
+			}
+			return 1
+		})
+	})
+}
+
+func waitForStatus(t *testing.T, id string, expected string) *pb.ReceiptStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, err := GetReceiptStatus(id)
+		if err == nil && status.Status == expected {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be %s, got %v (%v)", id, expected, status, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScoringJobs(t *testing.T) {
+	resetStore()
+	defer SetRules(DefaultRules)
+	defer SetRetryPolicy(DefaultRetryPolicy)
+	if err := SetRules(append([]string{"test-flaky"}, DefaultRules...)); err != nil {
+		t.Fatalf("could not set rules: %v", err)
+	}
+	kv := kvstore.New()
+
+	t.Run("RetriesWithBackoff", func(t *testing.T) {
+		if err := SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: "1ms"}); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		failures.Store(2)
+		id, err := ProcessReceipt(fraudReceipt("Flaky Foods", "10:00", "1.25"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		waitForStatus(t, id, StatusScored)
+		if _, err := kv.Get(fmt.Sprintf("job-%s", id)); err == nil {
+			t.Errorf("expected the job to be deleted once the receipt is scored")
+		}
+	})
+
+	t.Run("DeadLetterAndRequeue", func(t *testing.T) {
+		if err := SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: "1ms"}); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		failures.Store(2)
+		id, err := ProcessReceipt(fraudReceipt("Flaky Foods", "11:00", "1.25"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		waitForStatus(t, id, StatusFailed)
+
+		var deadLetter *pb.ScoringJob
+		for _, job := range DeadLetters() {
+			if job.ReceiptId == id {
+				deadLetter = job
+			}
+		}
+		if deadLetter == nil || deadLetter.Attempts != 2 || deadLetter.LastError != "scoring panicked: flaky rule" {
+			t.Fatalf("expected a dead letter after 2 attempts, got %v", deadLetter)
+		}
+
+		job, err := RequeueDeadLetter(id)
+		if err != nil || job.Attempts != 0 {
+			t.Fatalf("expected a fresh job, got %v (%v)", job, err)
+		}
+		waitForStatus(t, id, StatusScored)
+		if _, err := RequeueDeadLetter(id); !errors.Is(err, ErrNotDeadLettered) {
+			t.Errorf("expected ErrNotDeadLettered, got %v", err)
+		}
+	})
+
+	t.Run("RecoversStoredJobs", func(t *testing.T) {
+		// a receipt accepted before a crash, with its job still in the store
+		id := "recovered-receipt"
+		data, _ := protojson.Marshal(fraudReceipt("Recovered Goods", "12:00", "3.00"))
+		kv.Set(fmt.Sprintf("receipt-%s-receipt", id), string(data))
+		saveJob(id, &pb.ScoringJob{ReceiptId: id})
+
+		if err := StartWorkers(1, 5); err != nil {
+			t.Fatalf("could not start workers: %v", err)
+		}
+		defer StopWorkers()
+		waitForStatus(t, id, StatusScored)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		policy := RetryPolicy{MaxAttempts: 6, Backoff: "1s", MaxBackoff: "5s"}
+		if err := SetRetryPolicy(policy); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		policy = currentRetryPolicy()
+		for failures, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+			if delay := policy.delay(failures + 1); delay != expected {
+				t.Errorf("after %d failures expected %v, got %v", failures+1, expected, delay)
+			}
+		}
+	})
+
+	t.Run("RejectsBadPolicy", func(t *testing.T) {
+		if err := SetRetryPolicy(RetryPolicy{MaxAttempts: 0}); err == nil {
+			t.Errorf("expected zero attempts to be rejected")
+		}
+		if err := SetRetryPolicy(RetryPolicy{MaxAttempts: 1, Backoff: "soon"}); err == nil {
+			t.Errorf("expected an invalid duration to be rejected")
+		}
+	})
+}
@@ -0,0 +1,298 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Every change to anyone's points is a ledger entry that moves an amount from a debit account to
+// a credit account. Entries are numbered in order and stored once under "ledger-entry-<sequence>";
+// they are never changed or removed, a mistake is put right by a later entry. An account's balance
+// is its credits minus its debits, so the balances of all accounts always add up to zero.
+//
+// The balances and each receipt's points are kept alongside the entries so they can be read
+// without replaying the ledger. Reconcile replays it and checks they agree. The entries of each
+// receipt, and of each account holding points, are indexed under "ledger-receipt-<ID>-<sequence>"
+// and "ledger-account-<hash of account>-<sequence>".
+
+// Accounts other than the users' own.
+const (
+	// AccountPointsIssued is debited for every point awarded, and credited when points are taken back.
+	AccountPointsIssued = "points-issued"
+	// AccountAnonymous holds the points of receipts submitted without a user.
+	AccountAnonymous = "anonymous"
+)
+
+// ledgerMu keeps the sequence, the entries and the cached balances in step.
+var ledgerMu sync.Mutex
+
+// userAccount is the account holding a user's points.
+func userAccount(userID string) string {
+	if userID == "" {
+		return AccountAnonymous
+	}
+	return "user:" + userID
+}
+
+// post numbers an entry, stores it and updates the balances of both its accounts.
+func post(entry *pb.LedgerEntry) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	kv := kvstore.New()
+	sequence, err := kv.Increment("ledger-sequence", 1)
+	if err != nil {
+		fmt.Printf("Error numbering ledger entry: %v\n", err)
+		return
+	}
+	entry.Sequence = int64(sequence)
+	entry.At = now().UTC().Format(time.RFC3339Nano)
+	data, err := protojson.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Error storing ledger entry: %v\n", err)
+		return
+	}
+	key := ledgerKey(entry.Sequence)
+	if _, stored := kv.SetIfAbsent(key, string(data)); !stored {
+		fmt.Printf("Error storing ledger entry: %s already exists\n", key)
+		return
+	}
+	if entry.ReceiptId != "" {
+		kv.Set(fmt.Sprintf("ledger-receipt-%s-%020d", entry.ReceiptId, entry.Sequence), key)
+		if entry.Debit == AccountPointsIssued {
+			kv.Increment(postedKey(entry.ReceiptId), int(entry.Amount))
+		} else if entry.Credit == AccountPointsIssued {
+			kv.Increment(postedKey(entry.ReceiptId), -int(entry.Amount))
+		}
+	}
+	for _, account := range []string{entry.Debit, entry.Credit} {
+		if isHolderAccount(account) {
+			kv.Set(fmt.Sprintf("ledger-account-%s-%020d", userKey(account), entry.Sequence), key)
+		}
+	}
+	kv.Increment(balanceKey(entry.Debit), -int(entry.Amount))
+	kv.Increment(balanceKey(entry.Credit), int(entry.Amount))
+	recordEarnedPoints(entry)
+}
+
+// postReceiptPoints gives points to the holder of a receipt, or takes them back when points is
+// negative, and updates the receipt's points.
+func postReceiptPoints(id string, kind string, points int, reason string, ruleSetVersion string) {
+	if points != 0 {
+		userID := receiptUser(id)
+		entry := &pb.LedgerEntry{
+			Kind:           kind,
+			Debit:          AccountPointsIssued,
+			Credit:         userAccount(userID),
+			Amount:         int32(points),
+			Reason:         reason,
+			ReceiptId:      id,
+			RuleSetVersion: ruleSetVersion,
+			UserId:         userID,
+		}
+		if points < 0 {
+			entry.Debit, entry.Credit, entry.Amount = entry.Credit, entry.Debit, -entry.Amount
+		}
+		post(entry)
+		if userID != "" {
+			refreshTier(userID, now())
+		}
+	}
+
+	setReceiptPoints(id, storedPoints(id)+points)
+}
+
+// takeablePoints returns how many of points can be taken back from an account without leaving it
+// negative. The caller holds balanceMu.
+func takeablePoints(account string, points int) int {
+	return min(points, max(AccountBalance(account), 0))
+}
+
+// postedKey holds the net points the ledger has given a receipt, kept by post alongside the entries.
+func postedKey(id string) string {
+	return fmt.Sprintf("ledger-posted-%s", id)
+}
+
+// postedPoints returns the net points posted for a receipt, and false if nothing has been.
+func postedPoints(id string) (int, bool) {
+	kv := kvstore.New()
+	data, err := kv.Get(postedKey(id))
+	if err != nil {
+		return 0, false
+	}
+	points, _ := strconv.Atoi(data)
+	return points, true
+}
+
+// setReceiptPoints records the total points of a receipt.
+func setReceiptPoints(id string, total int) {
+	indexPoints(id, total)
+	kv := kvstore.New()
+	kv.Set(fmt.Sprintf("receipt-%s", id), strconv.Itoa(total))
+}
+
+// isHolderAccount reports whether an account holds points for someone, rather than tracking
+// where points came from or went.
+func isHolderAccount(account string) bool {
+	return account == AccountAnonymous || strings.HasPrefix(account, "user:")
+}
+
+// accountLedger returns the entries that moved points in or out of a holder account, in order.
+func accountLedger(account string) []*pb.LedgerEntry {
+	kv := kvstore.New()
+	entries := []*pb.LedgerEntry{}
+	for _, key := range kv.Keys(fmt.Sprintf("ledger-account-%s-", userKey(account))) {
+		entryKey, err := kv.Get(key)
+		if err != nil {
+			continue
+		}
+		if entry, err := loadLedgerEntry(entryKey); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// AccountBalance returns the credits minus the debits of an account.
+func AccountBalance(account string) int {
+	kv := kvstore.New()
+	data, err := kv.Get(balanceKey(account))
+	if err != nil {
+		return 0
+	}
+	balance, _ := strconv.Atoi(data)
+	return balance
+}
+
+// receiptLedger returns the entries that changed a receipt's points, in order.
+func receiptLedger(id string) []*pb.LedgerEntry {
+	kv := kvstore.New()
+	entries := []*pb.LedgerEntry{}
+	for _, key := range kv.Keys(fmt.Sprintf("ledger-receipt-%s-", id)) {
+		entryKey, err := kv.Get(key)
+		if err != nil {
+			continue
+		}
+		if entry, err := loadLedgerEntry(entryKey); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ReconciliationReport is the result of replaying the ledger.
+type ReconciliationReport struct {
+	Entries int
+	// Liability is the points held by users, and by anonymous receipts, that haven't been spent or expired.
+	Liability int
+	// Mismatches describes every cached balance or receipt total that disagrees with the ledger.
+	Mismatches []string
+}
+
+// Reconcile replays the ledger and checks that the entries are numbered without gaps, that every
+// cached balance and receipt total matches the entries and that the balances add up to zero.
+func Reconcile() *ReconciliationReport {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	kv := kvstore.New()
+	report := &ReconciliationReport{Mismatches: []string{}}
+	balances := map[string]int{}
+	receiptPoints := map[string]int{}
+	for i, key := range kv.Keys("ledger-entry-") {
+		entry, err := loadLedgerEntry(key)
+		if err != nil {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		report.Entries++
+		if entry.Sequence != int64(i+1) || key != ledgerKey(entry.Sequence) {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("%s: expected entry %d, found %d", key, i+1, entry.Sequence))
+		}
+		if entry.Amount <= 0 || entry.Debit == entry.Credit {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("entry %d: moves %d from %s to %s", entry.Sequence, entry.Amount, entry.Debit, entry.Credit))
+		}
+		balances[entry.Debit] -= int(entry.Amount)
+		balances[entry.Credit] += int(entry.Amount)
+		if entry.ReceiptId != "" {
+			if entry.Debit == AccountPointsIssued {
+				receiptPoints[entry.ReceiptId] += int(entry.Amount)
+			} else if entry.Credit == AccountPointsIssued {
+				receiptPoints[entry.ReceiptId] -= int(entry.Amount)
+			}
+		}
+	}
+
+	cached := map[string]int{}
+	for _, key := range kv.Keys("ledger-balance-") {
+		data, _ := kv.Get(key)
+		balance, _ := strconv.Atoi(data)
+		cached[strings.TrimPrefix(key, "ledger-balance-")] = balance
+	}
+	accounts := []string{}
+	for account := range balances {
+		accounts = append(accounts, account)
+	}
+	for account := range cached {
+		if _, ok := balances[account]; !ok {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Strings(accounts)
+	sum := 0
+	for _, account := range accounts {
+		sum += balances[account]
+		if cached[account] != balances[account] {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("account %s: balance is %d, the ledger says %d", account, cached[account], balances[account]))
+		}
+		if isHolderAccount(account) {
+			report.Liability += balances[account]
+			if balances[account] < 0 {
+				report.Mismatches = append(report.Mismatches, fmt.Sprintf("account %s: holds %d points", account, balances[account]))
+			}
+		}
+	}
+	if sum != 0 {
+		report.Mismatches = append(report.Mismatches, fmt.Sprintf("balances add up to %d instead of 0", sum))
+	}
+
+	for _, key := range kv.Keys("receipt-") {
+		if !strings.HasSuffix(key, "-status") {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(key, "receipt-"), "-status")
+		if points := storedPoints(id); points != receiptPoints[id] {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("receipt %s: has %d points, the ledger says %d", id, points, receiptPoints[id]))
+		}
+	}
+	return report
+}
+
+func ledgerKey(sequence int64) string {
+	return fmt.Sprintf("ledger-entry-%020d", sequence)
+}
+
+func balanceKey(account string) string {
+	return "ledger-balance-" + account
+}
+
+func loadLedgerEntry(key string) (*pb.LedgerEntry, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	entry := &pb.LedgerEntry{}
+	if err := protojson.Unmarshal([]byte(data), entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,116 @@
+package receiptprocessor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func mismatchesFor(report *ReconciliationReport, subject string) []string {
+	found := []string{}
+	for _, mismatch := range report.Mismatches {
+		if strings.Contains(mismatch, subject) {
+			found = append(found, mismatch)
+		}
+	}
+	return found
+}
+
+func TestLedger(t *testing.T) {
+	resetStore()
+	id, err := ProcessReceiptForUser("ledger-user", fraudReceipt("Ledger Lumber", "08:00", "9.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	awarded := storedPoints(id)
+	if _, err := VoidReceipt(id, "refunded"); err != nil {
+		t.Fatalf("could not void receipt: %v", err)
+	}
+
+	t.Run("Entries", func(t *testing.T) {
+		entries := receiptLedger(id)
+		if len(entries) != 2 {
+			t.Fatalf("expected an award and a void, got %v", entries)
+		}
+		award, void := entries[0], entries[1]
+		if award.Kind != EntryAward || award.Debit != AccountPointsIssued || award.Credit != "user:ledger-user" || int(award.Amount) != awarded {
+			t.Errorf("expected the award to move %d points from %s to the user, got %v", awarded, AccountPointsIssued, award)
+		}
+		if award.RuleSetVersion == "" {
+			t.Errorf("expected the award to record the rule set version, got %v", award)
+		}
+		if void.Kind != EntryVoid || void.Debit != "user:ledger-user" || void.Credit != AccountPointsIssued || void.Amount != award.Amount {
+			t.Errorf("expected the void to move the points back, got %v", void)
+		}
+		if void.Sequence <= award.Sequence {
+			t.Errorf("expected entries to be numbered in order, got %d then %d", award.Sequence, void.Sequence)
+		}
+		if balance := AccountBalance("user:ledger-user"); balance != 0 {
+			t.Errorf("expected a balance of 0, got %d", balance)
+		}
+	})
+
+	t.Run("Reconciles", func(t *testing.T) {
+		report := Reconcile()
+		if report.Entries < 2 {
+			t.Errorf("expected the entries to be replayed, got %d", report.Entries)
+		}
+		if found := append(mismatchesFor(report, "ledger-user"), mismatchesFor(report, id)...); len(found) > 0 {
+			t.Errorf("expected no mismatches, got %v", found)
+		}
+	})
+
+	t.Run("FindsTampering", func(t *testing.T) {
+		kv := kvstore.New()
+		kv.Set(balanceKey("user:ledger-user"), "500")
+		kv.Set("receipt-"+id, "500")
+		defer kv.Set(balanceKey("user:ledger-user"), "0")
+		defer kv.Set("receipt-"+id, "0")
+
+		report := Reconcile()
+		if found := mismatchesFor(report, "user:ledger-user"); len(found) != 1 {
+			t.Errorf("expected the overwritten balance to be found, got %v", report.Mismatches)
+		}
+		if found := mismatchesFor(report, "receipt "+id); len(found) != 1 {
+			t.Errorf("expected the overwritten receipt points to be found, got %v", report.Mismatches)
+		}
+	})
+
+	t.Run("FindsNegativeBalance", func(t *testing.T) {
+		post(&pb.LedgerEntry{Kind: EntryVoid, Debit: "user:ledger-overdrawn", Credit: AccountPointsIssued, Amount: 5})
+		defer post(&pb.LedgerEntry{Kind: EntryCorrection, Debit: AccountPointsIssued, Credit: "user:ledger-overdrawn", Amount: 5})
+
+		if found := mismatchesFor(Reconcile(), "user:ledger-overdrawn"); len(found) != 1 {
+			t.Errorf("expected the negative balance to be found, got %v", found)
+		}
+	})
+}
+
+func TestAwardAfterRestart(t *testing.T) {
+	id, err := ProcessReceiptForUser("restart-user", fraudReceipt("Restart Rugs", "08:00", "9.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	awarded := storedPoints(id)
+	balance := AccountBalance("user:restart-user")
+
+	// the process stopped after posting the award but before recording the receipt's points
+	kv := kvstore.New()
+	kv.Delete("receipt-" + id)
+	awardPoints(id, &pb.ScoreBreakdown{Total: int32(awarded)})
+
+	if entries := receiptLedger(id); len(entries) != 1 {
+		t.Errorf("expected the award to be posted once, got %v", entries)
+	}
+	if points := storedPoints(id); points != awarded {
+		t.Errorf("expected the receipt to have %d points, got %d", awarded, points)
+	}
+	if after := AccountBalance("user:restart-user"); after != balance {
+		t.Errorf("expected the balance to stay %d, got %d", balance, after)
+	}
+	if found := mismatchesFor(Reconcile(), id); len(found) > 0 {
+		t.Errorf("expected no mismatches, got %v", found)
+	}
+}
@@ -0,0 +1,51 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Money is an amount in cents. Keeping amounts as whole cents lets the rules do exact
+// integer arithmetic instead of comparing binary floats.
+type Money int64
+
+// MaxMoney is the largest amount accepted, a billion dollars, which keeps sums and percentages
+// of amounts well inside an int64.
+const MaxMoney Money = 1_000_000_000_00
+
+var errMoneyOverflow = errors.New("amount is too large")
+
+// ParseMoney parses a base currency amount in the "123.45" form used by the API.
+func ParseMoney(amount string) (Money, error) {
+	cents, err := parseMinorUnits(amount, minorUnits[BaseCurrency])
+	if err != nil {
+		return 0, err
+	}
+	if Money(cents) > MaxMoney {
+		return 0, fmt.Errorf("%w: %q", errMoneyOverflow, amount)
+	}
+	return Money(cents), nil
+}
+
+// Cents returns the amount as a whole number of cents.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// IsWholeDollar reports whether the amount has no cents.
+func (m Money) IsWholeDollar() bool {
+	return m%100 == 0
+}
+
+// IsMultipleOf reports whether the amount divides evenly by step.
+func (m Money) IsMultipleOf(step Money) bool {
+	return step != 0 && m%step == 0
+}
+
+func (m Money) String() string {
+	sign, cents := "", uint64(m)
+	if m < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,96 @@
+package receiptprocessor
+
+import (
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]Money{
+		"0.00":          0,
+		"0.29":          29,
+		"1.15":          115,
+		"35.35":         3535,
+		"1000000000.00": MaxMoney,
+	}
+	for amount, expected := range valid {
+		result, err := ParseMoney(amount)
+		if err != nil {
+			t.Errorf("could not parse %q: %v", amount, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("expected %q to parse to %d, got %d", amount, expected, result)
+		}
+		if result.String() != amount {
+			t.Errorf("expected %q to format back to itself, got %q", amount, result.String())
+		}
+	}
+
+	invalid := []string{"", "1", "1.5", "1.555", "-1.00", "FREE!", "1000000000.01", "92233720368547758.07", "92233720368547758.08", "100000000000000000000.00"}
+	for _, amount := range invalid {
+		if _, err := ParseMoney(amount); err == nil {
+			t.Errorf("expected %q to be rejected", amount)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	for money, expected := range map[Money]string{-5: "-0.05", -250: "-2.50", 250: "2.50", -9223372036854775808: "-92233720368547758.08"} {
+		if money.String() != expected {
+			t.Errorf("expected %d cents to format as %q, got %q", int64(money), expected, money.String())
+		}
+	}
+}
+
+func TestMoneyRules(t *testing.T) {
+	// amounts that trip up float64 arithmetic
+	cases := []struct {
+		total         string
+		roundDollar   int
+		quarterAmount int
+	}{
+		{"0.29", 0, 0},
+		{"1.15", 0, 0},
+		{"3.01", 0, 0},
+		{"0.25", 0, 25},
+		{"4.75", 0, 25},
+		{"9.00", 50, 25},
+		{"1000000000.00", 50, 25},
+		{"999999999.99", 0, 0},
+	}
+	for _, c := range cases {
+		total, err := ParseMoney(c.total)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", c.total, err)
+		}
+		receipt := &ParsedReceipt{Total: total}
+		if result := rule2.Process(receipt); result != c.roundDollar {
+			t.Errorf("rule 2 for %s: expected %d, got %d", c.total, c.roundDollar, result)
+		}
+		if result := rule3.Process(receipt); result != c.quarterAmount {
+			t.Errorf("rule 3 for %s: expected %d, got %d", c.total, c.quarterAmount, result)
+		}
+	}
+
+	// rule 5 rounds price * 0.2 up to the next point
+	prices := map[string]int{
+		"0.00":  0,
+		"0.01":  1,
+		"1.15":  1,
+		"5.00":  1,
+		"5.01":  2,
+		"12.25": 3,
+	}
+	for price, expected := range prices {
+		amount, err := ParseMoney(price)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", price, err)
+		}
+		item := ParsedItem{Item: &pb.Item{ShortDescription: "abc", Price: price}, Price: amount}
+		if result := processRule5LineItem(item); result != expected {
+			t.Errorf("rule 5 for %s: expected %d, got %d", price, expected, result)
+		}
+	}
+}
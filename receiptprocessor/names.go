@@ -0,0 +1,113 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// CharactersASCII allows ASCII letters and digits in names.
+	CharactersASCII = "ascii"
+	// CharactersUnicode allows letters and digits from any script, e.g. "Café Olé" or "ローソン".
+	CharactersUnicode = "unicode"
+)
+
+// NamePolicy decides which characters are allowed in retailer names and item descriptions.
+// Spaces and the punctuation allowed by the schema ('-', and '&' for retailers) are always accepted.
+type NamePolicy struct {
+	Characters string `json:"characters"`
+	// Scripts optionally limits letters to these Unicode scripts, e.g. ["Latin", "Katakana", "Han"].
+	Scripts []string `json:"scripts"`
+
+	scripts []*unicode.RangeTable
+}
+
+// DefaultNamePolicy accepts letters and digits from any script.
+var DefaultNamePolicy = NamePolicy{Characters: CharactersUnicode}
+
+var (
+	namePolicyMu sync.RWMutex
+	namePolicy   = DefaultNamePolicy
+)
+
+// SetNamePolicy validates and activates a character policy for names.
+func SetNamePolicy(policy NamePolicy) error {
+	switch policy.Characters {
+	case CharactersASCII, CharactersUnicode:
+	default:
+		return fmt.Errorf("unknown name characters policy %q", policy.Characters)
+	}
+	for _, script := range policy.Scripts {
+		table, ok := unicode.Scripts[script]
+		if !ok {
+			return fmt.Errorf("unknown Unicode script %q", script)
+		}
+		policy.scripts = append(policy.scripts, table)
+	}
+
+	namePolicyMu.Lock()
+	namePolicy = policy
+	namePolicyMu.Unlock()
+	return nil
+}
+
+// NormalizeReceipt puts the retailer and item descriptions into Unicode NFC form, so the same
+// name typed on different devices is stored, validated and scored the same way.
+func NormalizeReceipt(receipt *pb.Receipt) {
+	receipt.Retailer = norm.NFC.String(receipt.Retailer)
+	for _, item := range receipt.Items {
+		item.ShortDescription = norm.NFC.String(item.ShortDescription)
+	}
+}
+
+// validateNames checks the retailer and item descriptions against the name policy.
+func validateNames(receipt *pb.Receipt, errs *ValidationErrors) {
+	namePolicyMu.RLock()
+	policy := namePolicy
+	namePolicyMu.RUnlock()
+
+	// a field the schema already rejected isn't reported twice
+	if char, ok := policy.firstDisallowed(receipt.Retailer, "-&"); !ok && !errs.has("$.retailer") {
+		errs.add("$.retailer", CodeInvalid, "retailer contains %q, which is not allowed", char)
+	}
+	for i, item := range receipt.Items {
+		path := fmt.Sprintf("$.items[%d].shortDescription", i)
+		if char, ok := policy.firstDisallowed(item.ShortDescription, "-"); !ok && !errs.has(path) {
+			errs.add(path, CodeInvalid, "shortDescription contains %q, which is not allowed", char)
+		}
+	}
+}
+
+// firstDisallowed returns the first character the policy rejects, and false, or true if every character is allowed.
+func (p NamePolicy) firstDisallowed(name string, punctuation string) (rune, bool) {
+	for _, char := range name {
+		if unicode.IsSpace(char) || strings.ContainsRune(punctuation, char) || char == '_' {
+			continue
+		}
+		if !p.allows(char) {
+			return char, false
+		}
+	}
+	return 0, true
+}
+
+func (p NamePolicy) allows(char rune) bool {
+	if p.Characters == CharactersASCII {
+		return char < unicode.MaxASCII && (unicode.IsLetter(char) || unicode.IsDigit(char))
+	}
+	if unicode.IsDigit(char) || unicode.Is(unicode.M, char) {
+		return true
+	}
+	if !unicode.IsLetter(char) {
+		return false
+	}
+	if len(p.scripts) == 0 || unicode.Is(unicode.Common, char) {
+		return true
+	}
+	return unicode.IsOneOf(p.scripts, char)
+}
@@ -0,0 +1,105 @@
+package receiptprocessor
+
+import (
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func namesReceipt(retailer string, description string) *pb.Receipt {
+	return &pb.Receipt{
+		Retailer:     retailer,
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.00",
+		Items:        []*pb.Item{{ShortDescription: description, Price: "1.00"}},
+	}
+}
+
+func TestNames(t *testing.T) {
+	defer SetNamePolicy(DefaultNamePolicy)
+
+	t.Run("NormalizeReceipt", func(t *testing.T) {
+		// decomposed, with combining accents
+		receipt := namesReceipt("Cafe\u0301 Ole\u0301", "Cre\u0300me")
+		NormalizeReceipt(receipt)
+		if receipt.Retailer != "Café Olé" || receipt.Items[0].ShortDescription != "Crème" {
+			t.Errorf("expected NFC names, got %q and %q", receipt.Retailer, receipt.Items[0].ShortDescription)
+		}
+	})
+
+	t.Run("UnicodePolicy", func(t *testing.T) {
+		// Devanagari and Tamil use spacing combining marks, like the vowel signs in हिन्दी and மளிகை
+		for _, retailer := range []string{"Café Olé", "ローソン", "M&M Corner Market", "7-Eleven", "हिन्दी", "மளிகை"} {
+			if errs := ValidateReceipt(namesReceipt(retailer, "おにぎり")); len(errs) != 0 {
+				t.Errorf("expected %q to be valid, got %v", retailer, errs)
+			}
+		}
+		if errs := ValidateReceipt(namesReceipt("Café ☕", "Latte")); len(errs) != 1 || errs[0].Path != "$.retailer" {
+			t.Errorf("expected a symbol in the retailer to be rejected, got %v", errs)
+		}
+	})
+
+	t.Run("ASCIIPolicy", func(t *testing.T) {
+		if err := SetNamePolicy(NamePolicy{Characters: CharactersASCII}); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		if errs := ValidateReceipt(namesReceipt("Target", "Mountain Dew")); len(errs) != 0 {
+			t.Errorf("expected an ASCII receipt to be valid, got %v", errs)
+		}
+		errs := ValidateReceipt(namesReceipt("Café Olé", "おにぎり"))
+		if len(errs) != 2 || errs[0].Path != "$.retailer" || errs[1].Path != "$.items[0].shortDescription" {
+			t.Errorf("expected both names to be rejected, got %v", errs)
+		}
+	})
+
+	t.Run("ScriptsPolicy", func(t *testing.T) {
+		if err := SetNamePolicy(NamePolicy{Characters: CharactersUnicode, Scripts: []string{"Latin", "Katakana"}}); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		if errs := ValidateReceipt(namesReceipt("ローソン", "Café")); len(errs) != 0 {
+			t.Errorf("expected Katakana and Latin to be valid, got %v", errs)
+		}
+		if errs := ValidateReceipt(namesReceipt("ローソン", "おにぎり")); len(errs) != 1 {
+			t.Errorf("expected Hiragana to be rejected, got %v", errs)
+		}
+	})
+
+	t.Run("RejectsBadPolicy", func(t *testing.T) {
+		if err := SetNamePolicy(NamePolicy{Characters: "emoji"}); err == nil {
+			t.Errorf("expected an unknown character policy to be rejected")
+		}
+		if err := SetNamePolicy(NamePolicy{Characters: CharactersUnicode, Scripts: []string{"Klingon"}}); err == nil {
+			t.Errorf("expected an unknown script to be rejected")
+		}
+	})
+
+	t.Run("UnicodeRetailerRule", func(t *testing.T) {
+		cases := map[string]int{
+			"Target":            6,
+			"M&M Corner Market": 14,
+			"Café Olé":          7,
+			"Cafe\u0301":        4,
+			"ローソン":              4,
+			"セブン-イレブン 7":        8,
+		}
+		for retailer, expected := range cases {
+			receipt := &ParsedReceipt{Receipt: &pb.Receipt{Retailer: retailer}}
+			if result := unicodeRetailerRule.Process(receipt); result != expected {
+				t.Errorf("expected %d points for %q, got %d", expected, retailer, result)
+			}
+		}
+		// the original rule only counts ASCII
+		if result := rule1.Process(&ParsedReceipt{Receipt: &pb.Receipt{Retailer: "Café Olé"}}); result != 5 {
+			t.Errorf("expected 5 points for Café Olé from the ASCII rule, got %d", result)
+		}
+	})
+
+	t.Run("DescriptionLengthCountsCharacters", func(t *testing.T) {
+		// three characters, nine bytes
+		item := ParsedItem{Item: &pb.Item{ShortDescription: "おにぎ"}, Price: 1000}
+		if result := processRule5LineItem(item); result != 2 {
+			t.Errorf("expected 2, got %d", result)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package receiptprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// Stage orders the rules. Every base rule runs before any multiplier, and every
+// multiplier before any bonus, so later stages can build on the running subtotal.
+type Stage int
+
+const (
+	StageBase Stage = iota
+	StageMultiplier
+	StageBonus
+)
+
+var stageNames = []string{"base", "multiplier", "bonus"}
+
+func (s Stage) String() string {
+	if int(s) < len(stageNames) {
+		return stageNames[s]
+	}
+	return fmt.Sprintf("stage-%d", int(s))
+}
+
+// scoringPipeline is a rule set grouped into stages, with each stage ordered so that a
+// rule runs after every rule it depends on or is excluded by.
+type scoringPipeline struct {
+	stages [][]Rule
+	// version identifies the rules and the order they run in, so a score can be traced back to them
+	version string
+}
+
+func newScoringPipeline(rules []Rule) (*scoringPipeline, error) {
+	byName := map[string]Rule{}
+	for _, rule := range rules {
+		if _, ok := byName[rule.Name()]; ok {
+			return nil, fmt.Errorf("rule %q is listed twice", rule.Name())
+		}
+		if int(rule.Stage()) >= len(stageNames) || rule.Stage() < 0 {
+			return nil, fmt.Errorf("rule %q has an unknown stage %d", rule.Name(), rule.Stage())
+		}
+		byName[rule.Name()] = rule
+	}
+
+	grouped := make([][]Rule, len(stageNames))
+	for _, rule := range rules {
+		for _, name := range references(rule) {
+			other, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("rule %q refers to %q, which is not in the rule set", rule.Name(), name)
+			}
+			if other.Stage() > rule.Stage() {
+				return nil, fmt.Errorf("rule %q in the %s stage cannot refer to %q in the later %s stage", rule.Name(), rule.Stage(), name, other.Stage())
+			}
+		}
+		grouped[rule.Stage()] = append(grouped[rule.Stage()], rule)
+	}
+
+	pipeline := &scoringPipeline{}
+	for stage, stageRules := range grouped {
+		ordered, err := orderStage(stageRules)
+		if err != nil {
+			return nil, fmt.Errorf("%s stage: %w", Stage(stage), err)
+		}
+		pipeline.stages = append(pipeline.stages, ordered)
+	}
+	pipeline.version = pipeline.ruleSetVersion()
+	return pipeline, nil
+}
+
+// orderStage sorts the rules of one stage so every rule comes after the rules it refers to,
+// otherwise keeping the configured order.
+func orderStage(rules []Rule) ([]Rule, error) {
+	inStage := map[string]bool{}
+	for _, rule := range rules {
+		inStage[rule.Name()] = true
+	}
+
+	ordered := []Rule{}
+	placed := map[string]bool{}
+	for len(ordered) < len(rules) {
+		progress := false
+		for _, rule := range rules {
+			if placed[rule.Name()] {
+				continue
+			}
+			ready := true
+			for _, name := range references(rule) {
+				if inStage[name] && !placed[name] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, rule)
+				placed[rule.Name()] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("rules refer to each other in a cycle")
+		}
+	}
+	return ordered, nil
+}
+
+// ruleSetVersion hashes the rule names stage by stage, in the order they run.
+func (p *scoringPipeline) ruleSetVersion() string {
+	hash := sha256.New()
+	for stage, rules := range p.stages {
+		for _, rule := range rules {
+			fmt.Fprintf(hash, "%s:%s\n", Stage(stage), rule.Name())
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// score runs every stage in order and returns the breakdown of what each rule awarded.
+func (p *scoringPipeline) score(receipt *ParsedReceipt) *pb.ScoreBreakdown {
+	breakdown := &pb.ScoreBreakdown{RuleSetVersion: p.version}
+	awarded := map[string]int{}
+	subtotal := 0
+
+	for stage, rules := range p.stages {
+		stageBreakdown := &pb.StageBreakdown{Stage: Stage(stage).String()}
+		for _, rule := range rules {
+			result := &pb.RuleResult{Rule: rule.Name()}
+			if reason := skipReason(rule, awarded); reason != "" {
+				result.Skipped = reason
+			} else {
+				points := rule.Points(receipt, subtotal)
+				awarded[rule.Name()] = points
+				subtotal += points
+				result.Points = int32(points)
+			}
+			stageBreakdown.Rules = append(stageBreakdown.Rules, result)
+		}
+		stageBreakdown.Subtotal = int32(subtotal)
+		breakdown.Stages = append(breakdown.Stages, stageBreakdown)
+	}
+
+	breakdown.Total = int32(subtotal)
+	return breakdown
+}
+
+// references lists every rule a rule depends on or is excluded by.
+func references(rule Rule) []string {
+	names := []string{}
+	names = append(names, dependsOn(rule)...)
+	return append(names, excludes(rule)...)
+}
+
+// skipReason explains why a rule should not run, or returns "" if it should.
+func skipReason(rule Rule, awarded map[string]int) string {
+	for _, name := range dependsOn(rule) {
+		if awarded[name] == 0 {
+			return fmt.Sprintf("requires points from %s", name)
+		}
+	}
+	for _, name := range excludes(rule) {
+		if awarded[name] != 0 {
+			return fmt.Sprintf("excluded by %s", name)
+		}
+	}
+	return ""
+}
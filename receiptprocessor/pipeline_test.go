@@ -0,0 +1,129 @@
+package receiptprocessor
+
+import (
+	"testing"
+)
+
+func fixedRule(name string, stage Stage, points int) *pointRule {
+	return newStageRule(name, stage, func(_ *ParsedReceipt, _ int) int {
+		return points
+	})
+}
+
+func TestScoringPipeline(t *testing.T) {
+	t.Run("StagesRunInOrder", func(t *testing.T) {
+		double := newStageRule("double", StageMultiplier, func(_ *ParsedReceipt, subtotal int) int {
+			return subtotal
+		})
+		// listed out of order on purpose, the stages decide when rules run
+		pipeline, err := newScoringPipeline([]Rule{
+			fixedRule("bonus", StageBonus, 5),
+			double,
+			fixedRule("base-a", StageBase, 10),
+			fixedRule("base-b", StageBase, 20),
+		})
+		if err != nil {
+			t.Fatalf("could not build pipeline: %v", err)
+		}
+
+		breakdown := pipeline.score(&ParsedReceipt{})
+		if breakdown.Total != 65 {
+			t.Errorf("expected 65, got %d", breakdown.Total)
+		}
+		subtotals := []int32{30, 60, 65}
+		for i, stage := range breakdown.Stages {
+			if stage.Stage != stageNames[i] {
+				t.Errorf("expected stage %s, got %s", stageNames[i], stage.Stage)
+			}
+			if stage.Subtotal != subtotals[i] {
+				t.Errorf("expected %s subtotal %d, got %d", stage.Stage, subtotals[i], stage.Subtotal)
+			}
+		}
+	})
+
+	t.Run("DependsOnAndExcludes", func(t *testing.T) {
+		needsA := fixedRule("needs-a", StageBase, 3)
+		needsA.dependsOn = []string{"a"}
+		needsZero := fixedRule("needs-zero", StageBase, 4)
+		needsZero.dependsOn = []string{"zero"}
+		notWithA := fixedRule("not-with-a", StageBonus, 100)
+		notWithA.excludes = []string{"a"}
+
+		pipeline, err := newScoringPipeline([]Rule{
+			needsA,
+			needsZero,
+			notWithA,
+			fixedRule("a", StageBase, 1),
+			fixedRule("zero", StageBase, 0),
+		})
+		if err != nil {
+			t.Fatalf("could not build pipeline: %v", err)
+		}
+
+		breakdown := pipeline.score(&ParsedReceipt{})
+		if breakdown.Total != 4 {
+			t.Errorf("expected 4, got %d", breakdown.Total)
+		}
+		skipped := map[string]string{}
+		for _, stage := range breakdown.Stages {
+			for _, result := range stage.Rules {
+				skipped[result.Rule] = result.Skipped
+			}
+		}
+		if skipped["needs-a"] != "" {
+			t.Errorf("expected needs-a to run, got %q", skipped["needs-a"])
+		}
+		if skipped["needs-zero"] != "requires points from zero" {
+			t.Errorf("expected needs-zero to be skipped, got %q", skipped["needs-zero"])
+		}
+		if skipped["not-with-a"] != "excluded by a" {
+			t.Errorf("expected not-with-a to be excluded, got %q", skipped["not-with-a"])
+		}
+	})
+
+	t.Run("RejectsBadRuleSets", func(t *testing.T) {
+		missing := fixedRule("missing-dep", StageBase, 1)
+		missing.dependsOn = []string{"nope"}
+
+		early := fixedRule("early", StageBase, 1)
+		early.dependsOn = []string{"late"}
+
+		cycleA := fixedRule("cycle-a", StageBase, 1)
+		cycleA.dependsOn = []string{"cycle-b"}
+		cycleB := fixedRule("cycle-b", StageBase, 1)
+		cycleB.excludes = []string{"cycle-a"}
+
+		ruleSets := [][]Rule{
+			{missing},
+			{early, fixedRule("late", StageBonus, 1)},
+			{cycleA, cycleB},
+			{fixedRule("twice", StageBase, 1), fixedRule("twice", StageBase, 1)},
+		}
+		for _, rules := range ruleSets {
+			if _, err := newScoringPipeline(rules); err == nil {
+				t.Errorf("expected rule set starting with %s to be rejected", rules[0].Name())
+			}
+		}
+	})
+
+	t.Run("TuesdayDoublePoints", func(t *testing.T) {
+		rules, err := rulesByName([]string{"odd-purchase-day", "tuesday-double-points"})
+		if err != nil {
+			t.Fatalf("could not look up rules: %v", err)
+		}
+		pipeline, err := newScoringPipeline(rules)
+		if err != nil {
+			t.Fatalf("could not build pipeline: %v", err)
+		}
+
+		// 2025-01-21 is an odd-numbered Tuesday
+		tuesday := &ParsedReceipt{PurchaseDate: mustParseTime(t, "2006-01-02", "2025-01-21")}
+		if result := pipeline.score(tuesday).Total; result != 12 {
+			t.Errorf("expected 12, got %d", result)
+		}
+		thursday := &ParsedReceipt{PurchaseDate: mustParseTime(t, "2006-01-02", "2025-01-23")}
+		if result := pipeline.score(thursday).Total; result != 6 {
+			t.Errorf("expected 6, got %d", result)
+		}
+	})
+}
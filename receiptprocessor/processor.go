@@ -1,266 +1,178 @@
 package receiptprocessor
 
 import (
+	"context"
 	"fmt"
-	"math"
-	"regexp"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/keith-decker/fetch-assignment/kvstore"
 	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
-type pointRuleInterface interface {
-	Process(*pb.Receipt) int
-	isEnabled() bool
+func ProcessReceipt(receipt *pb.Receipt) (string, error) {
+	return ProcessReceiptForUser("", receipt)
 }
 
-type pointRule struct {
-	processFunc   func(*pb.Receipt) int
-	isEnabledFunc func() bool
+// ProcessReceiptForUser processes a receipt submitted on behalf of userID. The user ID is
+// optional; the receipt's points are added to the user's balance, and it is used to bucket
+// the receipt into an experiment arm and to look for fraud across the user's receipts.
+//
+// A receipt whose fingerprint matches an earlier one is not scored again. Depending on the
+// duplicate policy the original ID is returned, or the original ID with ErrDuplicateReceipt.
+//
+// When workers are running the receipt is scored in the background, and ErrQueueFull is
+// returned if there is no room for it.
+func ProcessReceiptForUser(userID string, receipt *pb.Receipt) (string, error) {
+	return processReceiptForUser(userID, receipt, reserveSlot)
 }
 
-func (p *pointRule) Process(receipt *pb.Receipt) int {
-	return p.processFunc(receipt)
+// ProcessReceiptWait is like ProcessReceiptForUser, but waits for room in the queue instead of
+// returning ErrQueueFull straight away. It gives up with ErrQueueFull once ctx is done.
+func ProcessReceiptWait(ctx context.Context, userID string, receipt *pb.Receipt) (string, error) {
+	return processReceiptForUser(userID, receipt, func() error {
+		return waitForSlot(ctx)
+	})
 }
 
-func (p pointRule) isEnabled() bool {
-	return p.isEnabledFunc()
-}
+func processReceiptForUser(userID string, receipt *pb.Receipt, reserve func() error) (string, error) {
+	NormalizeReceipt(receipt)
+
+	// reserve room in the queue before anything is stored, so a full queue turns the receipt away cleanly
+	if err := reserve(); err != nil {
+		return "", err
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			releaseSlot()
+		}
+	}()
 
-func ProcessReceipt(receipt *pb.Receipt) string {
-	// Generate an ID for this receipt
-	// @TODO: create a hash of the receipt to prevent duplicates. Date/Time + Store ID + Total?
+	// Generate an ID for this receipt, unless the same receipt was already submitted
 	id := uuid.New().String()
+	fingerprint := Fingerprint(receipt)
+	if original, claimed := claimFingerprint(fingerprint, id); !claimed {
+		if currentDuplicatePolicy() == DuplicatesReject {
+			return original, ErrDuplicateReceipt
+		}
+		return original, nil
+	}
 
 	// store the receipt in the KV store, kick off the processing and return the ID
 	kv := kvstore.New()
-	// set the score to -1 to indicate that the receipt is being processed
-	kv.Set(fmt.Sprintf("receipt-%s", id), "-1")
-
-	processReceipt(id, receipt)
-
-	return id
-}
-
-// dirty. I would refactor this to be more testable and extensible. I would also move the validation to a separate functions.
-func ValidateReceipt(receipt *pb.Receipt) bool {
-	errors := []string{}
-	// check retailer against regex "^[\\w\\s\\-&]+$"
-	matched, err := regexp.MatchString("^[\\w\\s\\-&]+$", receipt.Retailer)
-	if err != nil {
-		fmt.Printf("Error validating retailer regex: %v\n", err)
-		errors = append(errors, fmt.Sprintf("Error validating retailer: %v", receipt.Retailer))
-	}
-	if !matched {
-		errors = append(errors, fmt.Sprintf("Retailer name is invalid: %v", receipt.Retailer))
+	setStatus(id, StatusReceived, "")
+	kv.Set(fmt.Sprintf("receipt-%s-fingerprint", id), fingerprint)
+	if data, err := protojson.Marshal(receipt); err == nil {
+		kv.Set(fmt.Sprintf("receipt-%s-receipt", id), string(data))
 	}
+	indexReceipt(id, receipt, now())
+	assignUser(id, userID)
 
-	// Convert the date into a date object (Ideally this is done in the proto)
-	layout := "2006-01-02"
-	_, err = time.Parse(layout, receipt.PurchaseDate)
-	if err != nil {
-		fmt.Printf("Error validating purchase date regex: %v\n", err)
-		errors = append(errors, fmt.Sprintf("Error validating purchase date %v", receipt.PurchaseDate))
+	// a receipt that fails a soft check is held for review and earns no points until it is approved
+	setStatus(id, StatusValidating, "")
+	risk := assessRisk(userID, receipt)
+	storeRiskAssessment(id, risk)
+	if reasons := reviewReasons(receipt, risk); len(reasons) > 0 {
+		holdForReview(id, userID, risk, reasons)
+		setStatus(id, StatusPendingReview, strings.Join(reasons, "; "))
+		return id, nil
 	}
 
-	// check purchase time against regex "^[0-9]{2}:[0-9]{2}$"
-	matched, err = regexp.MatchString("^[0-9]{2}:[0-9]{2}$", receipt.PurchaseTime)
-	if err != nil {
-		fmt.Printf("Error validating purchase time regex: %v\n", err)
-		errors = append(errors, fmt.Sprintf("Error validating purchase time: %v", receipt.PurchaseTime))
-	}
-	if !matched {
-		errors = append(errors, fmt.Sprintf("Purchase time is invalid: %v", receipt.PurchaseTime))
-	}
-	// check total against regex "^\\d+\\.\\d{2}$"
-	matched, err = regexp.MatchString("^\\d+\\.\\d{2}$", receipt.Total)
-	if err != nil {
-		fmt.Printf("Error validating total regex: %v\n", err)
-		errors = append(errors, fmt.Sprintf("Error validating total: %v", receipt.Total))
-	}
-	if !matched {
-		errors = append(errors, fmt.Sprintf("Total is invalid: %v", receipt.Total))
-	}
-	// check items
-	shortDescRegex, err := regexp.Compile(`^[\w\s\-]+$`)
-	if err != nil {
-		fmt.Printf("Error compiling short description regex: %v\n", err)
-		errors = append(errors, "Error compiling short description regex")
-	}
-	priceRegex, err := regexp.Compile(`^\d+\.\d{2}$`)
-	if err != nil {
-		fmt.Printf("Error compiling price regex: %v\n", err)
-		errors = append(errors, "Error compiling price regex")
-	}
-
-	if len(receipt.Items) == 0 {
-		errors = append(errors, "No items on receipt")
-	}
+	queueScoring(id, userID, "")
+	queued = true
 
-	for _, item := range receipt.Items {
-		// check short description against regex "^[\\w\\s\\-]+$"
-		matched = shortDescRegex.MatchString(item.ShortDescription)
-		if !matched {
-			errors = append(errors, fmt.Sprintf("Item short description is invalid: %v", item.ShortDescription))
-		}
-		// check price against regex "^[\\w\\s\\-]+$"
-		matched = priceRegex.MatchString(item.Price)
-		if !matched {
-			errors = append(errors, fmt.Sprintf("Item price is invalid: %v", item.Price))
-		}
-	}
-	if len(errors) > 0 {
-		fmt.Printf("Receipt is invalid: %v\n", errors)
-		return false
-	}
-	return true
+	return id, nil
 }
 
-func processReceipt(id string, receipt *pb.Receipt) {
-	// Process the receipt
+// processReceipt scores a receipt, on a worker when they are running. An error means the
+// attempt should be retried; a receipt that can't be parsed is rejected instead.
+func processReceipt(id string, userID string, receipt *pb.Receipt) error {
 	kv := kvstore.New()
-	kv.Set(fmt.Sprintf("receipt-%s", id), "99")
 
-	totalScore := tallyScore(receipt)
-
-	kv.Set(fmt.Sprintf("receipt-%s", id), fmt.Sprintf("%d", totalScore))
-
-}
-
-// TallyScore takes a receipt and processes it against the rules to determine the total score.
-func tallyScore(receipt *pb.Receipt) int {
-	rules := defaultRules()
-	totalScore := 0
-	for _, rule := range rules {
-		if !rule.isEnabled() {
-			continue
-		}
-		totalScore += rule.Process(receipt)
+	pipeline := currentPipeline()
+	experiment, arm := assignExperimentArm(id, userID)
+	if arm != nil {
+		pipeline = arm.pipeline
 	}
-	return totalScore
-}
 
-// ------ These rules could be setup as individual modules/imports. ------
-
-func newPointRule(processFunc func(*pb.Receipt) int) *pointRule {
-	return &pointRule{
-		processFunc: processFunc,
-		isEnabledFunc: func() bool {
-			return true
-		},
-	}
-}
-
-var rule1 = newPointRule(func(receipt *pb.Receipt) int {
-	// One point for every alphanumeric character in the retailer name.
-	points := 0
-	toTest := strings.ToUpper(receipt.Retailer)
-	for _, char := range toTest {
-		if (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
-			points++
-		}
-	}
-	return points
-})
-
-var rule2 = newPointRule(func(receipt *pb.Receipt) int {
-	// 50 points if the total is a round dollar amount with no cents.
-	// convert the string to a float
-	receiptTotal, err := strconv.ParseFloat(receipt.Total, 64)
+	parsed, err := parseReceipt(receipt)
 	if err != nil {
-		fmt.Printf("Error converting receipt total to float (%s), returning 0\n", receipt.Total)
-		return 0
-	}
-	// check if the float is a whole number
-	if receiptTotal == float64(int(receiptTotal)) {
-		return 50
+		fmt.Printf("Error parsing receipt %s: %v\n", id, err)
+		setStatus(id, StatusRejected, err.Error())
+		return nil
 	}
-	return 0
-})
+	breakdown := tallyScore(parsed, pipeline)
+	// experiments compare rule sets, so their results leave out the tier multiplier
+	totalScore := int(breakdown.Total)
+	applyUserTier(breakdown, userID)
 
-var rule3 = newPointRule(func(receipt *pb.Receipt) int {
-	// 25 points if the total is a multiple of 0.25.
-	receiptTotal, err := strconv.ParseFloat(receipt.Total, 64)
+	data, err := protojson.Marshal(breakdown)
 	if err != nil {
-		fmt.Printf("Error converting receipt total to float (%s), returning 0\n", receipt.Total)
-		return 0
+		return fmt.Errorf("storing breakdown: %w", err)
 	}
-	decimalAmount := receiptTotal - float64(int(receiptTotal))
-	if int(decimalAmount*100)%25 == 0 {
-		return 25
+	kv.Set(fmt.Sprintf("receipt-%s-breakdown", id), string(data))
+	awardPoints(id, breakdown)
+	setStatus(id, StatusScored, "")
+
+	if arm != nil {
+		recordExperimentResult(id, experiment.Name, arm.Name, totalScore)
 	}
-	return 0
-})
+	return nil
+}
 
-var rule4 = newPointRule(func(receipt *pb.Receipt) int {
-	// 5 points for every two items on the receipt.
-	return int(len(receipt.Items)/2) * 5
-})
+// TallyScore takes a receipt and runs it through each stage of the pipeline to determine the total score.
+func tallyScore(receipt *ParsedReceipt, pipeline *scoringPipeline) *pb.ScoreBreakdown {
+	return pipeline.score(receipt)
+}
 
-var rule5 = newPointRule(func(receipt *pb.Receipt) int {
-	// If the trimmed length of the item description is a multiple of 3,
-	// multiply the price by 0.2 and round up to the nearest integer.
-	// The result is the number of points earned.
-	points := 0
-	for _, item := range receipt.Items {
-		points += processRule5LineItem(item)
+// storedReceipt returns the receipt as it was submitted, after normalization.
+func storedReceipt(id string) (*pb.Receipt, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(fmt.Sprintf("receipt-%s-receipt", id))
+	if err != nil {
+		return nil, err
 	}
-	return points
-})
-
-func processRule5LineItem(item *pb.Item) int {
-	// If the trimmed length of the item description is a multiple of 3,
-	// multiply the price by 0.2 and round up to the nearest integer.
-	// The result is the number of points earned.
-	trimmedDescription := strings.TrimSpace(item.ShortDescription)
-	if len(trimmedDescription)%3 == 0 {
-		price, err := strconv.ParseFloat(item.Price, 64)
-		if err != nil {
-			fmt.Printf("Error converting item price to float (%s), returning 0\n", item.Price)
-			return 0
-		}
-		return int(math.Ceil(price * 0.2))
+	receipt := &pb.Receipt{}
+	if err := protojson.Unmarshal([]byte(data), receipt); err != nil {
+		return nil, err
 	}
-	return 0
+	return receipt, nil
 }
 
-var rule6 = newPointRule(func(receipt *pb.Receipt) int {
-	// 6 points if the day in the purchase date is odd.
-
-	// Convert the date into a date object (Ideally this is done in the proto)
-	layout := "2006-01-02"
-	date, err := time.Parse(layout, receipt.PurchaseDate)
+// GetReceipt returns a stored receipt, as it was submitted after normalization or as it was last
+// corrected, with when it was submitted, its status and, once it is scored, its points and the
+// version of the rules that scored it.
+func GetReceipt(id string) (*pb.StoredReceipt, error) {
+	receipt, err := storedReceipt(id)
 	if err != nil {
-		fmt.Printf("Error converting purchase date to time (%s), returning 0\n", receipt.PurchaseDate)
-		return 0
+		return nil, err
 	}
-
-	if date.Day()%2 != 0 {
-		return 6
+	stored := &pb.StoredReceipt{Id: id, Receipt: receipt, UserId: receiptUser(id)}
+	if status, err := GetReceiptStatus(id); err == nil {
+		stored.Status = status.Status
+		if len(status.History) > 0 {
+			stored.SubmittedAt = status.History[0].At
+		}
 	}
-	return 0
-})
-
-var rule7 = newPointRule(func(receipt *pb.Receipt) int {
-	// 10 points if the time of purchase is after 2:00pm and before 4:00pm.
+	if breakdown, err := GetScoreBreakdown(id); err == nil {
+		stored.Points = int32(storedPoints(id))
+		stored.RuleSetVersion = breakdown.RuleSetVersion
+	}
+	return stored, nil
+}
 
-	// Convert the date into a date object (Ideally this is done in the proto)
-	layout := "15:04"
-	date, err := time.Parse(layout, receipt.PurchaseTime)
+// GetScoreBreakdown returns the per-stage, per-rule points awarded to a receipt.
+func GetScoreBreakdown(id string) (*pb.ScoreBreakdown, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(fmt.Sprintf("receipt-%s-breakdown", id))
 	if err != nil {
-		fmt.Printf("Error converting purchase date to time (%s), returning 0\n", receipt.PurchaseTime)
-		return 0
+		return nil, err
 	}
-	if date.Hour() >= 14 && date.Hour() < 16 {
-		return 10
+	breakdown := &pb.ScoreBreakdown{}
+	if err := protojson.Unmarshal([]byte(data), breakdown); err != nil {
+		return nil, err
 	}
-	return 0
-})
-
-func defaultRules() []pointRuleInterface {
-	return []pointRuleInterface{rule1, rule2, rule3, rule4, rule5, rule6, rule7}
+	return breakdown, nil
 }
@@ -2,11 +2,21 @@ package receiptprocessor
 
 import (
 	"testing"
+	"time"
 
+	"github.com/keith-decker/fetch-assignment/kvstore"
 	"github.com/keith-decker/fetch-assignment/pb"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
+// resetStore empties the store, so a test run again with -count starts from the same state.
+func resetStore() {
+	kv := kvstore.New()
+	for _, key := range kv.Keys("") {
+		kv.Delete(key)
+	}
+}
+
 func TestReceiptProcessorInternal(t *testing.T) {
 	var receipt1 = &pb.Receipt{}
 	var receipt2 = &pb.Receipt{}
@@ -23,6 +33,15 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		t.Fatalf("could not unmarshal receipt2: %v", err)
 	}
 
+	parsed1, err := parseReceipt(receipt1)
+	if err != nil {
+		t.Fatalf("could not parse receipt1: %v", err)
+	}
+	parsed2, err := parseReceipt(receipt2)
+	if err != nil {
+		t.Fatalf("could not parse receipt2: %v", err)
+	}
+
 	t.Run("ProcessReceipt1", func(t *testing.T) {
 		// Total Points: 28
 		// Breakdown:
@@ -36,42 +55,42 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		//   + ---------
 		//   = 28 points
 		expected := 6
-		if result := rule1.Process(receipt1); result != expected {
+		if result := rule1.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 0
-		if result := rule2.Process(receipt1); result != expected {
+		if result := rule2.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 0
-		if result := rule3.Process(receipt1); result != expected {
+		if result := rule3.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 0
-		if result := rule3.Process(receipt1); result != expected {
+		if result := rule3.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 10
-		if result := rule4.Process(receipt1); result != expected {
+		if result := rule4.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 6
-		if result := rule5.Process(receipt1); result != expected {
+		if result := rule5.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 6
-		if result := rule6.Process(receipt1); result != expected {
+		if result := rule6.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 0
-		if result := rule7.Process(receipt1); result != expected {
+		if result := rule7.Process(parsed1); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
@@ -89,37 +108,37 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		//   + ---------
 		//   = 109 points
 		expected := 14
-		if result := rule1.Process(receipt2); result != expected {
+		if result := rule1.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 50
-		if result := rule2.Process(receipt2); result != expected {
+		if result := rule2.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 25
-		if result := rule3.Process(receipt2); result != expected {
+		if result := rule3.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 10
-		if result := rule4.Process(receipt2); result != expected {
+		if result := rule4.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 0
-		if result := rule5.Process(receipt2); result != expected {
+		if result := rule5.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 0
-		if result := rule6.Process(receipt2); result != expected {
+		if result := rule6.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		expected = 10
-		if result := rule7.Process(receipt2); result != expected {
+		if result := rule7.Process(parsed2); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
@@ -129,21 +148,21 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		// Test Rule 4 with 2 items
 		// Total Points: 5
 		expected := 5
-		if result := rule4.Process(&pb.Receipt{Items: []*pb.Item{{}, {}}}); result != expected {
+		if result := rule4.Process(&ParsedReceipt{Items: make([]ParsedItem, 2)}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		// Test Rule 4 with 3 items
 		// Total Points: 5
 		expected = 5
-		if result := rule4.Process(&pb.Receipt{Items: []*pb.Item{{}, {}, {}}}); result != expected {
+		if result := rule4.Process(&ParsedReceipt{Items: make([]ParsedItem, 3)}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		// Test Rule 4 with 4 items
 		// Total Points: 10
 		expected = 10
-		if result := rule4.Process(&pb.Receipt{Items: []*pb.Item{{}, {}, {}, {}}}); result != expected {
+		if result := rule4.Process(&ParsedReceipt{Items: make([]ParsedItem, 4)}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
@@ -151,7 +170,7 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		// "Emils Cheese Pizza" is 18 characters (a multiple of 3)
 		// item price of 12.25 * 0.2 = 2.45, rounded up is 3 points
 		expected = 3
-		lineItem := &pb.Item{ShortDescription: "Emils Cheese Pizza", Price: "12.25"}
+		lineItem := ParsedItem{Item: &pb.Item{ShortDescription: "Emils Cheese Pizza", Price: "12.25"}, Price: 1225}
 		if result := processRule5LineItem(lineItem); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
@@ -160,7 +179,7 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		// "Klarbrunn 12-PK 12 FL OZ" is 24 characters (a multiple of 3)
 		// item price of 12.00 * 0.2 = 2.4, rounded up is 3 points
 		expected = 3
-		lineItem = &pb.Item{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"}
+		lineItem = ParsedItem{Item: &pb.Item{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"}, Price: 1200}
 		if result := processRule5LineItem(lineItem); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
@@ -168,7 +187,7 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		// Test Rule 5 Line Item for Non-Multiple of 3
 		// "Gatorade" is 7 characters (not a multiple of 3)
 		expected = 0
-		lineItem = &pb.Item{ShortDescription: "Gatorade", Price: "2.25"}
+		lineItem = ParsedItem{Item: &pb.Item{ShortDescription: "Gatorade", Price: "2.25"}, Price: 225}
 		if result := processRule5LineItem(lineItem); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
@@ -176,29 +195,38 @@ func TestReceiptProcessorInternal(t *testing.T) {
 		// Test Rule 6 for Odd Day
 		// Total Points: 6
 		expected = 6
-		if result := rule6.Process(&pb.Receipt{PurchaseDate: "2025-01-21"}); result != expected {
+		if result := rule6.Process(&ParsedReceipt{PurchaseDate: mustParseTime(t, "2006-01-02", "2025-01-21")}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		// Test Rule 6 for Even Day
 		// Total Points: 0
 		expected = 0
-		if result := rule6.Process(&pb.Receipt{PurchaseDate: "2025-01-22"}); result != expected {
+		if result := rule6.Process(&ParsedReceipt{PurchaseDate: mustParseTime(t, "2006-01-02", "2025-01-22")}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		// Test Rule 7 for After 2:00pm and Before 4:00pm
 		// Total Points: 10
 		expected = 10
-		if result := rule7.Process(&pb.Receipt{PurchaseTime: "14:33"}); result != expected {
+		if result := rule7.Process(&ParsedReceipt{PurchaseTime: mustParseTime(t, "15:04", "14:33")}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 
 		// Test Rule 7 for Before 2:00pm
 		// Total Points: 0
 		expected = 0
-		if result := rule7.Process(&pb.Receipt{PurchaseTime: "13:59"}); result != expected {
+		if result := rule7.Process(&ParsedReceipt{PurchaseTime: mustParseTime(t, "15:04", "13:59")}); result != expected {
 			t.Errorf("expected %d, got %d", expected, result)
 		}
 	})
 }
+
+func mustParseTime(t *testing.T, layout string, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(layout, value)
+	if err != nil {
+		t.Fatalf("could not parse %q: %v", value, err)
+	}
+	return parsed
+}
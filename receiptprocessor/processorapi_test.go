@@ -44,11 +44,13 @@ func TestReceiptProcessor(t *testing.T) {
 		//   = 28 points
 		expected := 28
 		// get the id
-		isValid := receiptprocessor.ValidateReceipt(receipt1)
-		if !isValid {
-			t.Errorf("expected valid receipt, got invalid")
+		if errs := receiptprocessor.ValidateReceipt(receipt1); len(errs) > 0 {
+			t.Errorf("expected valid receipt, got %v", errs)
+		}
+		id, err := receiptprocessor.ProcessReceipt(receipt1)
+		if err != nil {
+			t.Fatalf("could not process receipt1: %v", err)
 		}
-		id := receiptprocessor.ProcessReceipt(receipt1)
 		// pause for a moment to allow the kv store to update
 		time.Sleep(1 * time.Second)
 		// get the points
@@ -78,11 +80,13 @@ func TestReceiptProcessor(t *testing.T) {
 		//   = 109 points
 		expected := 109
 		// get the id
-		isValid := receiptprocessor.ValidateReceipt(receipt2)
-		if !isValid {
-			t.Errorf("expected valid receipt, got invalid")
+		if errs := receiptprocessor.ValidateReceipt(receipt2); len(errs) > 0 {
+			t.Errorf("expected valid receipt, got %v", errs)
+		}
+		id, err := receiptprocessor.ProcessReceipt(receipt2)
+		if err != nil {
+			t.Fatalf("could not process receipt2: %v", err)
 		}
-		id := receiptprocessor.ProcessReceipt(receipt2)
 		// pause for a moment to allow the kv store to update
 		time.Sleep(1 * time.Second)
 		// get the points
@@ -107,9 +111,21 @@ func TestReceiptProcessor(t *testing.T) {
 		if err != nil {
 			t.Fatalf("could not unmarshal invalidReceipt: %v", err)
 		}
-		isValid := receiptprocessor.ValidateReceipt(invalidReceipt)
-		if isValid {
-			t.Errorf("expected invalid receipt, got valid")
+		errs := receiptprocessor.ValidateReceipt(invalidReceipt)
+		expected := map[string]string{
+			"$.retailer":                  receiptprocessor.CodeRequired,
+			"$.purchaseDate":              receiptprocessor.CodeInvalid,
+			"$.purchaseTime":              receiptprocessor.CodeInvalid,
+			"$.items[0].shortDescription": receiptprocessor.CodeRequired,
+			"$.items[0].price":            receiptprocessor.CodeInvalid,
+		}
+		if len(errs) != len(expected) {
+			t.Errorf("expected %d errors, got %v", len(expected), errs)
+		}
+		for _, err := range errs {
+			if expected[err.Path] != err.Code {
+				t.Errorf("expected %s to have code %q, got %q", err.Path, expected[err.Path], err.Code)
+			}
 		}
 	})
 
@@ -121,9 +137,9 @@ func TestReceiptProcessor(t *testing.T) {
 		if err != nil {
 			t.Fatalf("could not unmarshal invalidReceipt: %v", err)
 		}
-		isValid := receiptprocessor.ValidateReceipt(invalidReceipt)
-		if isValid {
-			t.Errorf("expected invalid receipt, got valid")
+		errs := receiptprocessor.ValidateReceipt(invalidReceipt)
+		if len(errs) != 1 || errs[0].Path != "$.purchaseDate" {
+			t.Errorf("expected a purchaseDate error, got %v", errs)
 		}
 	})
 }
@@ -0,0 +1,66 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// ParsedReceipt is a receipt with its amounts, date and time parsed once, so the rules
+// work on typed values instead of re-parsing the strings. Amounts are normalized to the
+// base currency; the original strings are still available through the embedded pb.Receipt.
+type ParsedReceipt struct {
+	*pb.Receipt
+	Currency     string
+	Total        Money
+	Items        []ParsedItem
+	PurchaseDate time.Time
+	PurchaseTime time.Time
+}
+
+// ParsedItem is a line item with its price normalized to the base currency.
+type ParsedItem struct {
+	*pb.Item
+	Price Money
+}
+
+func parseReceipt(receipt *pb.Receipt) (*ParsedReceipt, error) {
+	currency, err := receiptCurrency(receipt.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := parseAmount(receipt.Total, currency)
+	if err != nil {
+		return nil, fmt.Errorf("total: %w", err)
+	}
+
+	purchaseDate, err := time.Parse("2006-01-02", receipt.PurchaseDate)
+	if err != nil {
+		return nil, fmt.Errorf("purchase date: %w", err)
+	}
+
+	purchaseTime, err := time.Parse("15:04", receipt.PurchaseTime)
+	if err != nil {
+		return nil, fmt.Errorf("purchase time: %w", err)
+	}
+
+	items := make([]ParsedItem, 0, len(receipt.Items))
+	for i, item := range receipt.Items {
+		price, err := parseAmount(item.Price, currency)
+		if err != nil {
+			return nil, fmt.Errorf("item %d price: %w", i, err)
+		}
+		items = append(items, ParsedItem{Item: item, Price: price})
+	}
+
+	return &ParsedReceipt{
+		Receipt:      receipt,
+		Currency:     currency,
+		Total:        total,
+		Items:        items,
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+	}, nil
+}
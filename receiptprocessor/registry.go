@@ -0,0 +1,118 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Rule awards points for a receipt. Rules are registered by name with RegisterRule and
+// selected by name from configuration.
+type Rule interface {
+	Name() string
+	Stage() Stage
+	// Points returns the points to add, given the subtotal of every rule that ran before it.
+	Points(receipt *ParsedReceipt, subtotal int) int
+}
+
+// RuleDependencies can be implemented by a Rule that depends on or excludes other rules.
+type RuleDependencies interface {
+	// DependsOn lists rules that must award points before this rule runs.
+	DependsOn() []string
+	// Excludes lists rules that stop this rule from running if they award points.
+	Excludes() []string
+}
+
+// RuleFactory creates a new instance of a rule.
+type RuleFactory func() Rule
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]RuleFactory{}
+)
+
+// RegisterRule makes a rule available by name. It is meant to be called from init() and,
+// like database/sql.Register, panics if the name is registered twice or the factory is nil.
+func RegisterRule(name string, factory RuleFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("receiptprocessor: RegisterRule factory is nil for " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic("receiptprocessor: RegisterRule called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// RegisteredRules returns the names of every registered rule, sorted.
+func RegisteredRules() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rulesByName creates the registered rules by name, preserving the requested order.
+func rulesByName(names []string) ([]Rule, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	rules := []Rule{}
+	for _, name := range names {
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		rule := factory()
+		if rule == nil || rule.Name() != name {
+			return nil, fmt.Errorf("rule factory for %q did not return a rule with that name", name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func dependsOn(rule Rule) []string {
+	if deps, ok := rule.(RuleDependencies); ok {
+		return deps.DependsOn()
+	}
+	return nil
+}
+
+func excludes(rule Rule) []string {
+	if deps, ok := rule.(RuleDependencies); ok {
+		return deps.Excludes()
+	}
+	return nil
+}
+
+var (
+	pipelineMu     sync.RWMutex
+	activePipeline *scoringPipeline
+)
+
+// SetRules selects the registered rules, by name, that score receipts outside of an experiment.
+func SetRules(names []string) error {
+	rules, err := rulesByName(names)
+	if err != nil {
+		return err
+	}
+	pipeline, err := newScoringPipeline(rules)
+	if err != nil {
+		return err
+	}
+	pipelineMu.Lock()
+	activePipeline = pipeline
+	pipelineMu.Unlock()
+	return nil
+}
+
+func currentPipeline() *scoringPipeline {
+	pipelineMu.RLock()
+	defer pipelineMu.RUnlock()
+	return activePipeline
+}
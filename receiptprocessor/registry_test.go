@@ -0,0 +1,80 @@
+package receiptprocessor_test
+
+import (
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+)
+
+// registered the same way another package in the monorepo would contribute a rule
+func init() {
+	receiptprocessor.RegisterRule("test-flat-bonus", func() receiptprocessor.Rule {
+		return receiptprocessor.NewRule("test-flat-bonus", receiptprocessor.StageBonus, func(receipt *receiptprocessor.ParsedReceipt, subtotal int) int {
+			return 1000
+		})
+	})
+	receiptprocessor.RegisterRule("test-misnamed", func() receiptprocessor.Rule {
+		return receiptprocessor.NewRule("something-else", receiptprocessor.StageBase, func(receipt *receiptprocessor.ParsedReceipt, subtotal int) int {
+			return 0
+		})
+	})
+}
+
+func TestRuleRegistry(t *testing.T) {
+	defer receiptprocessor.SetRules(receiptprocessor.DefaultRules)
+
+	t.Run("RegisteredRulesIncludeBuiltins", func(t *testing.T) {
+		found := map[string]bool{}
+		for _, name := range receiptprocessor.RegisteredRules() {
+			found[name] = true
+		}
+		for _, name := range append(receiptprocessor.DefaultRules, "tuesday-double-points", "test-flat-bonus") {
+			if !found[name] {
+				t.Errorf("expected %s to be registered", name)
+			}
+		}
+	})
+
+	t.Run("DuplicateRegistrationPanics", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected registering item-pairs twice to panic")
+			}
+		}()
+		receiptprocessor.RegisterRule("item-pairs", func() receiptprocessor.Rule { return nil })
+	})
+
+	t.Run("SetRulesRejectsUnknownAndMisnamed", func(t *testing.T) {
+		if err := receiptprocessor.SetRules([]string{"not-registered"}); err == nil {
+			t.Errorf("expected an unknown rule to be rejected")
+		}
+		if err := receiptprocessor.SetRules([]string{"test-misnamed"}); err == nil {
+			t.Errorf("expected a rule whose name does not match its registration to be rejected")
+		}
+	})
+
+	t.Run("SelectedRulesScoreReceipts", func(t *testing.T) {
+		if err := receiptprocessor.SetRules([]string{"item-pairs", "test-flat-bonus"}); err != nil {
+			t.Fatalf("could not set rules: %v", err)
+		}
+		receipt := &pb.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "2.00",
+			Items:        []*pb.Item{{ShortDescription: "Gum", Price: "1.00"}, {ShortDescription: "Gum", Price: "1.00"}},
+		}
+		id, err := receiptprocessor.ProcessReceipt(receipt)
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		breakdown, err := receiptprocessor.GetScoreBreakdown(id)
+		if err != nil {
+			t.Fatalf("could not get breakdown: %v", err)
+		}
+		if breakdown.Total != 1005 {
+			t.Errorf("expected 1005, got %d", breakdown.Total)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Review statuses. A held receipt is pending until an admin approves or rejects it.
+const (
+	ReviewPending  = "pending_review"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+var (
+	// ErrNotPendingReview is returned when deciding on a receipt that isn't waiting for review.
+	ErrNotPendingReview = errors.New("receipt is not pending review")
+	// ErrReviewReasonRequired is returned when rejecting a receipt without saying why.
+	ErrReviewReasonRequired = errors.New("a reason is required to reject a receipt")
+)
+
+// reviewReasons lists why a receipt should be held for review instead of scored: a risk score
+// at or above the hold score, or consistency warnings when the policy sends those to review.
+func reviewReasons(receipt *pb.Receipt, risk *pb.RiskAssessment) []string {
+	reasons := []string{}
+	if risk.Held {
+		for _, signal := range risk.Signals {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", signal.Name, signal.Detail))
+		}
+	}
+
+	consistencyMu.RLock()
+	reviewWarnings := consistencyPolicy.ReviewWarnings
+	consistencyMu.RUnlock()
+	if reviewWarnings {
+		if parsed, err := parseReceipt(receipt); err == nil {
+			_, warnings := checkConsistency(parsed)
+			for _, warning := range warnings {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", warning.Code, warning.Error()))
+			}
+		}
+	}
+	return reasons
+}
+
+// holdForReview puts a receipt in the review queue instead of scoring it.
+func holdForReview(id string, userID string, risk *pb.RiskAssessment, reasons []string) {
+	storeReview(fmt.Sprintf("review-pending-%s", id), &pb.ReviewItem{
+		Id:        id,
+		UserId:    userID,
+		Reasons:   reasons,
+		RiskScore: risk.Score,
+		Status:    ReviewPending,
+		HeldAt:    now().UTC().Format(time.RFC3339),
+	})
+}
+
+// PendingReviews returns the receipts waiting for review, oldest first.
+func PendingReviews() ([]*pb.ReviewItem, error) {
+	kv := kvstore.New()
+	reviews := []*pb.ReviewItem{}
+	for _, key := range kv.Keys("review-pending-") {
+		review, err := loadReview(key)
+		if err != nil {
+			// decided since the keys were listed
+			continue
+		}
+		reviews = append(reviews, review)
+	}
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return reviews[i].HeldAt < reviews[j].HeldAt
+	})
+	return reviews, nil
+}
+
+// GetReview returns the review of a held receipt, pending or decided.
+func GetReview(id string) (*pb.ReviewItem, error) {
+	if review, err := loadReview(fmt.Sprintf("review-pending-%s", id)); err == nil {
+		return review, nil
+	}
+	return loadReview(fmt.Sprintf("receipt-%s-review", id))
+}
+
+// ApproveReceipt releases a held receipt and queues it for scoring.
+func ApproveReceipt(id string) (*pb.ReviewItem, error) {
+	if err := reserveSlot(); err != nil {
+		return nil, err
+	}
+	review, err := decideReview(id, ReviewApproved, "")
+	if err != nil {
+		releaseSlot()
+		return nil, err
+	}
+	queueScoring(id, review.UserId, "approved in review")
+	return review, nil
+}
+
+// RejectReceipt closes the review of a held receipt without awarding any points.
+func RejectReceipt(id string, reason string) (*pb.ReviewItem, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrReviewReasonRequired
+	}
+	review, err := decideReview(id, ReviewRejected, reason)
+	if err != nil {
+		return nil, err
+	}
+	setStatus(id, StatusRejected, reason)
+	return review, nil
+}
+
+// decideReview takes a receipt off the queue, so only one decision is ever made for it.
+func decideReview(id string, status string, reason string) (*pb.ReviewItem, error) {
+	kv := kvstore.New()
+	data, err := kv.Take(fmt.Sprintf("review-pending-%s", id))
+	if err != nil {
+		return nil, ErrNotPendingReview
+	}
+	review := &pb.ReviewItem{}
+	if err := protojson.Unmarshal([]byte(data), review); err != nil {
+		return nil, err
+	}
+
+	review.Status = status
+	review.DecisionReason = reason
+	review.DecidedAt = now().UTC().Format(time.RFC3339)
+	storeReview(fmt.Sprintf("receipt-%s-review", id), review)
+	return review, nil
+}
+
+func storeReview(key string, review *pb.ReviewItem) {
+	kv := kvstore.New()
+	if data, err := protojson.Marshal(review); err == nil {
+		kv.Set(key, string(data))
+	}
+}
+
+func loadReview(key string) (*pb.ReviewItem, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	review := &pb.ReviewItem{}
+	if err := protojson.Unmarshal([]byte(data), review); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
@@ -0,0 +1,125 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// holdReceipt submits a receipt for a user twice within minutes, so the second is held as a near-duplicate.
+func holdReceipt(t *testing.T, retailer string) string {
+	t.Helper()
+	userID := "hold-" + retailer
+	if _, err := ProcessReceiptForUser(userID, fraudReceipt(retailer, "15:00", "6.00")); err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	id, err := ProcessReceiptForUser(userID, fraudReceipt(retailer, "15:03", "6.00"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	return id
+}
+
+func isPending(id string) bool {
+	reviews, _ := PendingReviews()
+	for _, review := range reviews {
+		if review.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReviewQueue(t *testing.T) {
+	resetStore()
+	kv := kvstore.New()
+
+	t.Run("HeldReceiptsArePending", func(t *testing.T) {
+		id := holdReceipt(t, "Queue Cafe")
+		if !isPending(id) {
+			t.Fatalf("expected %s to be pending review", id)
+		}
+		review, err := GetReview(id)
+		if err != nil || review.Status != ReviewPending || review.RiskScore != 60 || len(review.Reasons) != 1 {
+			t.Errorf("expected a pending review with one reason, got %v (%v)", review, err)
+		}
+	})
+
+	t.Run("ApproveWithFullQueue", func(t *testing.T) {
+		id := holdReceipt(t, "Crowded Cafe")
+		if err := StartWorkers(1, 1); err != nil {
+			t.Fatalf("could not start workers: %v", err)
+		}
+		defer StopWorkers()
+		if err := reserveSlot(); err != nil {
+			t.Fatalf("could not reserve a slot: %v", err)
+		}
+		if _, err := ApproveReceipt(id); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+		releaseSlot()
+		if !isPending(id) {
+			t.Errorf("expected %s to stay pending so the approval can be retried", id)
+		}
+	})
+
+	t.Run("ApproveScores", func(t *testing.T) {
+		id := holdReceipt(t, "Approved Cafe")
+		review, err := ApproveReceipt(id)
+		if err != nil || review.Status != ReviewApproved || review.DecidedAt == "" {
+			t.Fatalf("expected an approved review, got %v (%v)", review, err)
+		}
+		if isPending(id) {
+			t.Errorf("expected %s to leave the queue", id)
+		}
+		breakdown, err := GetScoreBreakdown(id)
+		if err != nil || breakdown.Total == 0 {
+			t.Errorf("expected the approved receipt to be scored, got %v (%v)", breakdown, err)
+		}
+		if _, err := ApproveReceipt(id); !errors.Is(err, ErrNotPendingReview) {
+			t.Errorf("expected a second approval to fail with ErrNotPendingReview, got %v", err)
+		}
+	})
+
+	t.Run("RejectNeedsReason", func(t *testing.T) {
+		id := holdReceipt(t, "Rejected Cafe")
+		if _, err := RejectReceipt(id, " "); !errors.Is(err, ErrReviewReasonRequired) {
+			t.Errorf("expected ErrReviewReasonRequired, got %v", err)
+		}
+		review, err := RejectReceipt(id, "same purchase uploaded twice")
+		if err != nil || review.Status != ReviewRejected || review.DecisionReason != "same purchase uploaded twice" {
+			t.Fatalf("expected a rejected review, got %v (%v)", review, err)
+		}
+		if points, err := kv.Get(fmt.Sprintf("receipt-%s", id)); err == nil {
+			t.Errorf("expected a rejected receipt to have no points, got %s", points)
+		}
+		if status, err := GetReceiptStatus(id); err != nil || status.Status != StatusRejected {
+			t.Errorf("expected the receipt to be rejected, got %v (%v)", status, err)
+		}
+		if review, err := GetReview(id); err != nil || review.Status != ReviewRejected {
+			t.Errorf("expected the decision to be kept, got %v (%v)", review, err)
+		}
+	})
+
+	t.Run("ReviewWarnings", func(t *testing.T) {
+		defer SetConsistencyPolicy(DefaultConsistencyPolicy)
+		policy := DefaultConsistencyPolicy
+		policy.ReviewWarnings = true
+		if err := SetConsistencyPolicy(policy); err != nil {
+			t.Fatalf("could not set policy: %v", err)
+		}
+		receipt := fraudReceipt("Mismatch Market", "16:00", "10.00")
+		receipt.Items = []*pb.Item{{ShortDescription: "Bread", Price: "2.00"}}
+		id, err := ProcessReceipt(receipt)
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		review, err := GetReview(id)
+		if err != nil || len(review.Reasons) != 1 || review.RiskScore != 0 {
+			t.Errorf("expected the item sum warning to send the receipt to review, got %v (%v)", review, err)
+		}
+	})
+}
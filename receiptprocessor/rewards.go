@@ -0,0 +1,214 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Rewards are kept under "reward-<ID>" and redemptions under "redemption-<ID>", indexed by user
+// under "index-redemption-<hash of user ID>-<redemption ID>". Redeeming a reward posts its cost
+// from the user's account to AccountPointsRedeemed.
+
+// AccountPointsRedeemed is credited with the points users spend on rewards.
+const AccountPointsRedeemed = "points-redeemed"
+
+// EntryRedemption is the kind of ledger entry posted when a user redeems a reward.
+const EntryRedemption = "redemption"
+
+var (
+	// ErrRewardNotFound is returned for a reward that isn't in the catalog.
+	ErrRewardNotFound = errors.New("reward not found")
+	// ErrRewardExists is returned when adding a reward with an ID that is already in the catalog.
+	ErrRewardExists = errors.New("a reward with that ID already exists")
+	// ErrInvalidReward is returned for a reward without a name, or with a cost or inventory out of range.
+	ErrInvalidReward = errors.New("invalid reward")
+	// ErrOutOfStock is returned when redeeming a reward with no inventory left.
+	ErrOutOfStock = errors.New("reward is out of stock")
+	// ErrInsufficientPoints is returned when a user's balance doesn't cover the cost of a reward.
+	ErrInsufficientPoints = errors.New("not enough points")
+)
+
+var rewardIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// balanceMu serializes the changes that check a balance or an inventory before taking from it,
+// so two of them can't both spend the same points or the last reward.
+var balanceMu sync.Mutex
+
+// AddReward adds a reward to the catalog, with a new ID if it doesn't have one.
+func AddReward(reward *pb.Reward) (*pb.Reward, error) {
+	if reward.Id == "" {
+		reward.Id = uuid.New().String()
+	}
+	if err := validateReward(reward); err != nil {
+		return nil, err
+	}
+	data, err := protojson.Marshal(reward)
+	if err != nil {
+		return nil, err
+	}
+	kv := kvstore.New()
+	if _, added := kv.SetIfAbsent(fmt.Sprintf("reward-%s", reward.Id), string(data)); !added {
+		return nil, ErrRewardExists
+	}
+	return reward, nil
+}
+
+// UpdateReward replaces the name, cost and inventory of a reward in the catalog.
+func UpdateReward(id string, reward *pb.Reward) (*pb.Reward, error) {
+	reward.Id = id
+	if err := validateReward(reward); err != nil {
+		return nil, err
+	}
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+	if _, err := GetReward(id); err != nil {
+		return nil, err
+	}
+	saveReward(reward)
+	return reward, nil
+}
+
+// RemoveReward takes a reward out of the catalog. Past redemptions of it are kept.
+func RemoveReward(id string) error {
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+	kv := kvstore.New()
+	if _, err := kv.Take(fmt.Sprintf("reward-%s", id)); err != nil {
+		return ErrRewardNotFound
+	}
+	return nil
+}
+
+// GetReward returns a reward from the catalog.
+func GetReward(id string) (*pb.Reward, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(fmt.Sprintf("reward-%s", id))
+	if err != nil {
+		return nil, ErrRewardNotFound
+	}
+	reward := &pb.Reward{}
+	if err := protojson.Unmarshal([]byte(data), reward); err != nil {
+		return nil, err
+	}
+	return reward, nil
+}
+
+// Rewards returns the catalog, ordered by ID.
+func Rewards() []*pb.Reward {
+	kv := kvstore.New()
+	rewards := []*pb.Reward{}
+	for _, key := range kv.Keys("reward-") {
+		if reward, err := GetReward(strings.TrimPrefix(key, "reward-")); err == nil {
+			rewards = append(rewards, reward)
+		}
+	}
+	return rewards
+}
+
+// Redeem spends a user's points on a reward. The balance is checked, the points debited and the
+// inventory decremented together, so the user can't overspend and the reward can't be oversold.
+func Redeem(userID string, rewardID string) (*pb.Redemption, error) {
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+
+	reward, err := GetReward(rewardID)
+	if err != nil {
+		return nil, err
+	}
+	if reward.Inventory < 1 {
+		return nil, ErrOutOfStock
+	}
+	account := userAccount(userID)
+	if AccountBalance(account) < int(reward.Cost) {
+		return nil, ErrInsufficientPoints
+	}
+
+	redemption := &pb.Redemption{
+		Id:         uuid.New().String(),
+		UserId:     userID,
+		RewardId:   reward.Id,
+		RewardName: reward.Name,
+		Cost:       reward.Cost,
+		RedeemedAt: now().UTC().Format(time.RFC3339Nano),
+	}
+	post(&pb.LedgerEntry{
+		Kind:   EntryRedemption,
+		Debit:  account,
+		Credit: AccountPointsRedeemed,
+		Amount: reward.Cost,
+		Reason: fmt.Sprintf("redeemed %s (%s)", reward.Name, redemption.Id),
+		UserId: userID,
+	})
+	reward.Inventory--
+	saveReward(reward)
+
+	redemption.Balance = int32(AccountBalance(account))
+	if data, err := protojson.Marshal(redemption); err == nil {
+		kv := kvstore.New()
+		kv.Set(fmt.Sprintf("redemption-%s", redemption.Id), string(data))
+		kv.Set(fmt.Sprintf("index-redemption-%s-%s", userKey(userID), redemption.Id), redemption.Id)
+	}
+	return redemption, nil
+}
+
+// UserRedemptions returns the rewards a user redeemed, oldest first.
+func UserRedemptions(userID string) []*pb.Redemption {
+	kv := kvstore.New()
+	redemptions := []*pb.Redemption{}
+	for _, key := range kv.Keys(fmt.Sprintf("index-redemption-%s-", userKey(userID))) {
+		id, err := kv.Get(key)
+		if err != nil {
+			continue
+		}
+		data, err := kv.Get(fmt.Sprintf("redemption-%s", id))
+		if err != nil {
+			continue
+		}
+		redemption := &pb.Redemption{}
+		if err := protojson.Unmarshal([]byte(data), redemption); err == nil {
+			redemptions = append(redemptions, redemption)
+		}
+	}
+	// RFC 3339 times drop trailing zeros from the fraction, so they don't sort as strings
+	sort.SliceStable(redemptions, func(i, j int) bool {
+		return redeemedAt(redemptions[i]).Before(redeemedAt(redemptions[j]))
+	})
+	return redemptions
+}
+
+func redeemedAt(redemption *pb.Redemption) time.Time {
+	at, _ := time.Parse(time.RFC3339Nano, redemption.RedeemedAt)
+	return at
+}
+
+func validateReward(reward *pb.Reward) error {
+	reward.Name = strings.TrimSpace(reward.Name)
+	switch {
+	case !rewardIDPattern.MatchString(reward.Id):
+		return fmt.Errorf("%w: the ID can only contain letters, digits, dashes and underscores", ErrInvalidReward)
+	case reward.Name == "":
+		return fmt.Errorf("%w: a name is required", ErrInvalidReward)
+	case reward.Cost < 1:
+		return fmt.Errorf("%w: the cost must be at least 1 point", ErrInvalidReward)
+	case reward.Inventory < 0:
+		return fmt.Errorf("%w: the inventory cannot be negative", ErrInvalidReward)
+	}
+	return nil
+}
+
+func saveReward(reward *pb.Reward) {
+	kv := kvstore.New()
+	if data, err := protojson.Marshal(reward); err == nil {
+		kv.Set(fmt.Sprintf("reward-%s", reward.Id), string(data))
+	}
+}
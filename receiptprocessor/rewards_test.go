@@ -0,0 +1,134 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+func TestRedeem(t *testing.T) {
+	resetStore()
+	id, err := ProcessReceiptForUser("redeem-user", fraudReceipt("Redeem Records", "11:00", "4.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	earned := storedPoints(id)
+	reward, err := AddReward(&pb.Reward{Id: "redeem-mug", Name: "Mug", Cost: int32(earned / 2), Inventory: 1})
+	if err != nil {
+		t.Fatalf("could not add reward: %v", err)
+	}
+
+	t.Run("Catalog", func(t *testing.T) {
+		if _, err := AddReward(&pb.Reward{Id: "redeem-mug", Name: "Mug", Cost: 1}); !errors.Is(err, ErrRewardExists) {
+			t.Errorf("expected ErrRewardExists, got %v", err)
+		}
+		if _, err := AddReward(&pb.Reward{Name: " ", Cost: 1}); !errors.Is(err, ErrInvalidReward) {
+			t.Errorf("expected ErrInvalidReward without a name, got %v", err)
+		}
+		if _, err := AddReward(&pb.Reward{Name: "Free", Cost: 0}); !errors.Is(err, ErrInvalidReward) {
+			t.Errorf("expected ErrInvalidReward without a cost, got %v", err)
+		}
+		if _, err := UpdateReward("missing", &pb.Reward{Name: "Mug", Cost: 1}); !errors.Is(err, ErrRewardNotFound) {
+			t.Errorf("expected ErrRewardNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		redemption, err := Redeem("redeem-user", reward.Id)
+		if err != nil {
+			t.Fatalf("could not redeem reward: %v", err)
+		}
+		if int(redemption.Balance) != earned-int(reward.Cost) || redemption.RewardName != "Mug" {
+			t.Errorf("expected a balance of %d after redeeming a mug, got %v", earned-int(reward.Cost), redemption)
+		}
+		if stored, _ := GetReward(reward.Id); stored.Inventory != 0 {
+			t.Errorf("expected the inventory to be decremented, got %v", stored)
+		}
+		if redemptions := UserRedemptions("redeem-user"); len(redemptions) != 1 || redemptions[0].Id != redemption.Id {
+			t.Errorf("expected the redemption to be listed, got %v", redemptions)
+		}
+	})
+
+	t.Run("OutOfStock", func(t *testing.T) {
+		if _, err := Redeem("redeem-user", reward.Id); !errors.Is(err, ErrOutOfStock) {
+			t.Errorf("expected ErrOutOfStock, got %v", err)
+		}
+	})
+
+	t.Run("InsufficientPoints", func(t *testing.T) {
+		if _, err := AddReward(&pb.Reward{Id: "redeem-bike", Name: "Bike", Cost: int32(earned), Inventory: 5}); err != nil {
+			t.Fatalf("could not add reward: %v", err)
+		}
+		if _, err := Redeem("redeem-user", "redeem-bike"); !errors.Is(err, ErrInsufficientPoints) {
+			t.Errorf("expected ErrInsufficientPoints, got %v", err)
+		}
+		if _, err := Redeem("redeem-user", "missing"); !errors.Is(err, ErrRewardNotFound) {
+			t.Errorf("expected ErrRewardNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		if _, err := ProcessReceiptForUser("redeem-racer", fraudReceipt("Redeem Radios", "12:00", "5.10")); err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		balance := AccountBalance(userAccount("redeem-racer"))
+		if _, err := AddReward(&pb.Reward{Id: "redeem-pen", Name: "Pen", Cost: int32(balance), Inventory: 10}); err != nil {
+			t.Fatalf("could not add reward: %v", err)
+		}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		redeemed := 0
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := Redeem("redeem-racer", "redeem-pen"); err == nil {
+					mu.Lock()
+					redeemed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if redeemed != 1 || AccountBalance(userAccount("redeem-racer")) != 0 {
+			t.Errorf("expected exactly one redemption, got %d", redeemed)
+		}
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		if _, err := ProcessReceiptForUser("redeem-sorter", fraudReceipt("Redeem Rugs", "12:00", "4.10")); err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		if _, err := AddReward(&pb.Reward{Id: "redeem-clip", Name: "Clip", Cost: 1, Inventory: 2}); err != nil {
+			t.Fatalf("could not add reward: %v", err)
+		}
+		defer func() { now = time.Now }()
+		// "…:01.1Z" sorts before "…:01Z" as a string
+		second := time.Date(2024, 2, 1, 9, 0, 1, 100_000_000, time.UTC)
+		for _, at := range []time.Time{second, second.Truncate(time.Second)} {
+			now = func() time.Time { return at }
+			if _, err := Redeem("redeem-sorter", "redeem-clip"); err != nil {
+				t.Fatalf("could not redeem reward: %v", err)
+			}
+		}
+		redemptions := UserRedemptions("redeem-sorter")
+		if len(redemptions) != 2 || redemptions[0].RedeemedAt != "2024-02-01T09:00:01Z" {
+			t.Errorf("expected the redemptions oldest first, got %v", redemptions)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		if err := RemoveReward(reward.Id); err != nil {
+			t.Fatalf("could not remove reward: %v", err)
+		}
+		if err := RemoveReward(reward.Id); !errors.Is(err, ErrRewardNotFound) {
+			t.Errorf("expected ErrRewardNotFound, got %v", err)
+		}
+		if redemptions := UserRedemptions("redeem-user"); len(redemptions) != 1 {
+			t.Errorf("expected the redemption to be kept, got %v", redemptions)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package receiptprocessor
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultRules are the rules used when configuration does not select any.
+var DefaultRules = []string{
+	"retailer-alphanumeric",
+	"round-dollar-total",
+	"quarter-multiple-total",
+	"item-pairs",
+	"item-description-length",
+	"odd-purchase-day",
+	"afternoon-purchase",
+}
+
+func init() {
+	for _, rule := range []*pointRule{rule1, rule2, rule3, rule4, rule5, rule6, rule7, tuesdayDoublePoints, unicodeRetailerRule} {
+		RegisterRule(rule.name, rule.factory)
+	}
+	if err := SetRules(DefaultRules); err != nil {
+		panic(err)
+	}
+}
+
+// pointRule is a Rule backed by a function, used for the built-in rules.
+type pointRule struct {
+	name       string
+	stage      Stage
+	dependsOn  []string
+	excludes   []string
+	pointsFunc func(*ParsedReceipt, int) int
+}
+
+func (p *pointRule) Name() string {
+	return p.name
+}
+
+func (p *pointRule) Stage() Stage {
+	return p.stage
+}
+
+func (p *pointRule) DependsOn() []string {
+	return p.dependsOn
+}
+
+func (p *pointRule) Excludes() []string {
+	return p.excludes
+}
+
+func (p *pointRule) Points(receipt *ParsedReceipt, subtotal int) int {
+	return p.pointsFunc(receipt, subtotal)
+}
+
+// Process scores the receipt on its own, without any earlier subtotal.
+func (p *pointRule) Process(receipt *ParsedReceipt) int {
+	return p.pointsFunc(receipt, 0)
+}
+
+// the built-in rules are stateless, so every factory call can share one instance
+func (p *pointRule) factory() Rule {
+	return p
+}
+
+// NewRule builds a Rule from a function, for packages that register simple rules.
+func NewRule(name string, stage Stage, points func(receipt *ParsedReceipt, subtotal int) int) Rule {
+	return newStageRule(name, stage, points)
+}
+
+func newPointRule(name string, processFunc func(*ParsedReceipt) int) *pointRule {
+	return newStageRule(name, StageBase, func(receipt *ParsedReceipt, _ int) int {
+		return processFunc(receipt)
+	})
+}
+
+// newStageRule creates a rule for a later stage, which can see the subtotal of the earlier stages.
+func newStageRule(name string, stage Stage, pointsFunc func(*ParsedReceipt, int) int) *pointRule {
+	return &pointRule{
+		name:       name,
+		stage:      stage,
+		pointsFunc: pointsFunc,
+	}
+}
+
+var rule1 = newPointRule("retailer-alphanumeric", func(receipt *ParsedReceipt) int {
+	// One point for every alphanumeric character in the retailer name.
+	points := 0
+	toTest := strings.ToUpper(receipt.Retailer)
+	for _, char := range toTest {
+		if (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
+			points++
+		}
+	}
+	return points
+})
+
+var unicodeRetailerRule = newPointRule("retailer-unicode-alphanumeric", func(receipt *ParsedReceipt) int {
+	// One point for every letter or digit in the retailer name, in any script.
+	// The name is NFC normalized first so an accented letter counts once.
+	points := 0
+	for _, char := range norm.NFC.String(receipt.Retailer) {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			points++
+		}
+	}
+	return points
+})
+
+var rule2 = newPointRule("round-dollar-total", func(receipt *ParsedReceipt) int {
+	// 50 points if the total is a round dollar amount with no cents.
+	if receipt.Total.IsWholeDollar() {
+		return 50
+	}
+	return 0
+})
+
+var rule3 = newPointRule("quarter-multiple-total", func(receipt *ParsedReceipt) int {
+	// 25 points if the total is a multiple of 0.25.
+	if receipt.Total.IsMultipleOf(25) {
+		return 25
+	}
+	return 0
+})
+
+var rule4 = newPointRule("item-pairs", func(receipt *ParsedReceipt) int {
+	// 5 points for every two items on the receipt.
+	return int(len(receipt.Items)/2) * 5
+})
+
+var rule5 = newPointRule("item-description-length", func(receipt *ParsedReceipt) int {
+	// If the trimmed length of the item description is a multiple of 3,
+	// multiply the price by 0.2 and round up to the nearest integer.
+	// The result is the number of points earned.
+	points := 0
+	for _, item := range receipt.Items {
+		points += processRule5LineItem(item)
+	}
+	return points
+})
+
+func processRule5LineItem(item ParsedItem) int {
+	// If the trimmed length of the item description is a multiple of 3,
+	// multiply the price by 0.2 and round up to the nearest integer.
+	// The result is the number of points earned.
+	// The length is counted in characters, not bytes, so non-Latin descriptions score fairly.
+	trimmedDescription := strings.TrimSpace(item.ShortDescription)
+	if utf8.RuneCountInString(trimmedDescription)%3 == 0 {
+		// price * 0.2 in dollars is cents / 500, rounded up
+		return int((item.Price.Cents() + 499) / 500)
+	}
+	return 0
+}
+
+var rule6 = newPointRule("odd-purchase-day", func(receipt *ParsedReceipt) int {
+	// 6 points if the day in the purchase date is odd.
+	if receipt.PurchaseDate.Day()%2 != 0 {
+		return 6
+	}
+	return 0
+})
+
+var rule7 = newPointRule("afternoon-purchase", func(receipt *ParsedReceipt) int {
+	// 10 points if the time of purchase is after 2:00pm and before 4:00pm.
+	if receipt.PurchaseTime.Hour() >= 14 && receipt.PurchaseTime.Hour() < 16 {
+		return 10
+	}
+	return 0
+})
+
+var tuesdayDoublePoints = newStageRule("tuesday-double-points", StageMultiplier, func(receipt *ParsedReceipt, subtotal int) int {
+	// Double every point earned in the base stage if the purchase was on a Tuesday.
+	if receipt.PurchaseDate.Weekday() == time.Tuesday {
+		return subtotal
+	}
+	return 0
+})
@@ -0,0 +1,73 @@
+package receiptprocessor
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/openapi"
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// TestMain validates receipts against the real api.yml, the same way the server does.
+func TestMain(m *testing.M) {
+	data, err := os.ReadFile("../api.yml")
+	if err != nil {
+		log.Fatalf("could not read api.yml: %v", err)
+	}
+	spec, err := openapi.Load(data)
+	if err != nil {
+		log.Fatalf("could not load api.yml: %v", err)
+	}
+	schema, err := spec.RequestSchema("/receipts/process", "post")
+	if err != nil {
+		log.Fatalf("could not find the receipt schema: %v", err)
+	}
+	SetReceiptSchema(schema)
+	os.Exit(m.Run())
+}
+
+func TestValidateWithoutSchema(t *testing.T) {
+	schemaMu.RLock()
+	schema := receiptSchema
+	schemaMu.RUnlock()
+	SetReceiptSchema(nil)
+	defer SetReceiptSchema(schema)
+
+	if errs := ValidateReceipt(&pb.Receipt{Retailer: "", Total: "1.00"}); len(errs) == 0 || errs[0].Code != CodeUnsupported {
+		t.Errorf("expected every receipt to be rejected without a schema, got %v", errs)
+	}
+	if _, errs, _ := ValidateReceiptJSON([]byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Gum","price":"1.00"}],"total":"1.00"}`)); len(errs) == 0 {
+		t.Error("expected a valid receipt to be rejected without a schema")
+	}
+}
+
+func TestValidateReceiptJSON(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		receipt, errs, _ := ValidateReceiptJSON([]byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Gum","price":"1.00"}],"total":"1.00"}`))
+		if len(errs) != 0 || receipt == nil || receipt.Retailer != "Target" {
+			t.Errorf("expected the receipt to be valid, got %v: %v", receipt, errs)
+		}
+	})
+
+	t.Run("AsSent", func(t *testing.T) {
+		// a re-marshalled receipt would drop the empty retailer and report it as missing
+		_, errs, _ := ValidateReceiptJSON([]byte(`{"retailer":"","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Gum","price":"1.00"}],"total":"1.00"}`))
+		if len(errs) != 1 || errs[0].Path != "$.retailer" || errs[0].Code != CodeInvalid {
+			t.Errorf("expected the empty retailer to fail its pattern, got %v", errs)
+		}
+	})
+
+	t.Run("WrongType", func(t *testing.T) {
+		receipt, errs, _ := ValidateReceiptJSON([]byte(`{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[{"shortDescription":"Gum","price":"1.00"}],"total":1.00}`))
+		if receipt != nil || len(errs) != 1 || errs[0].Path != "$.total" {
+			t.Errorf("expected the numeric total to be reported against its field, got %v", errs)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		if _, errs, _ := ValidateReceiptJSON([]byte(`{"retailer":`)); len(errs) != 1 || errs[0].Code != CodeMalformed {
+			t.Errorf("expected a malformed error, got %v", errs)
+		}
+	})
+}
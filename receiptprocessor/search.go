@@ -0,0 +1,249 @@
+package receiptprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// Receipts are indexed under "index-<field>-<value>-<receipt ID>", with values written so their
+// keys sort in value order: dates as YYYY-MM-DD, totals and points zero-padded, submission times
+// in a fixed-width layout and retailers as a hash of their canonical name. The processor keeps
+// the indexes up to date as receipts are stored, change status and are scored.
+
+// Fields receipts can be sorted by.
+const (
+	SortBySubmittedAt  = "submittedAt"
+	SortByPurchaseDate = "purchaseDate"
+	SortByTotal        = "total"
+	SortByPoints       = "points"
+)
+
+const (
+	// DefaultSearchLimit is the page size when none is given.
+	DefaultSearchLimit = 50
+	// MaxSearchLimit is the largest page size.
+	MaxSearchLimit = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't returned by the same search.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var sortIndexes = map[string]string{
+	SortBySubmittedAt:  "submitted",
+	SortByPurchaseDate: "date",
+	SortByTotal:        "total",
+	SortByPoints:       "points",
+}
+
+const submittedLayout = "2006-01-02T15:04:05.000000000Z"
+
+// ReceiptQuery filters, sorts and pages through the stored receipts. Empty fields don't filter.
+type ReceiptQuery struct {
+	// UserID matches the receipts submitted by a user.
+	UserID string
+	// Retailer matches the retailer name, ignoring case and spacing.
+	Retailer string
+	// PurchasedFrom and PurchasedTo are inclusive YYYY-MM-DD dates.
+	PurchasedFrom string
+	PurchasedTo   string
+	// MinTotal and MaxTotal are inclusive amounts in the base currency.
+	MinTotal *Money
+	MaxTotal *Money
+	// MinPoints and MaxPoints are inclusive. Receipts that aren't scored never match them.
+	MinPoints *int
+	MaxPoints *int
+	Status    string
+	// SortBy is one of the SortBy constants, SortBySubmittedAt by default. Sorting by points
+	// only lists scored receipts.
+	SortBy     string
+	Descending bool
+	Limit      int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+}
+
+// SearchReceipts returns a page of the receipts matching the query, in order.
+func SearchReceipts(query ReceiptQuery) (*pb.ReceiptPage, error) {
+	if query.SortBy == "" {
+		query.SortBy = SortBySubmittedAt
+	}
+	index, ok := sortIndexes[query.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort by %q", query.SortBy)
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	if query.Limit > MaxSearchLimit {
+		query.Limit = MaxSearchLimit
+	}
+	prefix := fmt.Sprintf("index-%s-", index)
+	after := ""
+	if query.Cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+		if err != nil || !strings.HasPrefix(string(decoded), prefix) {
+			return nil, ErrInvalidCursor
+		}
+		after = string(decoded)
+	}
+
+	// equality filters narrow the candidates through their own indexes before any receipt is loaded
+	var candidates map[string]bool
+	if query.UserID != "" {
+		candidates = intersect(candidates, indexedIDs(fmt.Sprintf("index-user-%s-", userKey(query.UserID))))
+	}
+	if query.Retailer != "" {
+		candidates = intersect(candidates, indexedIDs(fmt.Sprintf("index-retailer-%s-", retailerKey(query.Retailer))))
+	}
+	if query.Status != "" {
+		candidates = intersect(candidates, indexedIDs(fmt.Sprintf("index-status-%s-", query.Status)))
+	}
+
+	kv := kvstore.New()
+	keys := kv.Keys(prefix)
+	if query.Descending {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	page := &pb.ReceiptPage{Receipts: []*pb.StoredReceipt{}}
+	for _, key := range keys {
+		if after != "" && (!query.Descending && key <= after || query.Descending && key >= after) {
+			continue
+		}
+		id, err := kv.Get(key)
+		if err != nil || candidates != nil && !candidates[id] {
+			continue
+		}
+		receipt, err := GetReceipt(id)
+		if err != nil || !query.matches(receipt) {
+			continue
+		}
+		if len(page.Receipts) == query.Limit {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(after))
+			break
+		}
+		page.Receipts = append(page.Receipts, receipt)
+		after = key
+	}
+	return page, nil
+}
+
+// matches checks the filters that aren't answered by an index.
+func (q ReceiptQuery) matches(receipt *pb.StoredReceipt) bool {
+	date := receipt.Receipt.GetPurchaseDate()
+	if q.PurchasedFrom != "" && date < q.PurchasedFrom || q.PurchasedTo != "" && date > q.PurchasedTo {
+		return false
+	}
+	if q.MinTotal != nil || q.MaxTotal != nil {
+		parsed, err := parseReceipt(receipt.Receipt)
+		if err != nil || q.MinTotal != nil && parsed.Total < *q.MinTotal || q.MaxTotal != nil && parsed.Total > *q.MaxTotal {
+			return false
+		}
+	}
+	if q.MinPoints != nil || q.MaxPoints != nil {
+		if receipt.RuleSetVersion == "" {
+			return false
+		}
+		points := int(receipt.Points)
+		if q.MinPoints != nil && points < *q.MinPoints || q.MaxPoints != nil && points > *q.MaxPoints {
+			return false
+		}
+	}
+	return true
+}
+
+// indexReceipt adds a newly stored receipt to the submission time index and the indexes of its fields.
+func indexReceipt(id string, receipt *pb.Receipt, submittedAt time.Time) {
+	kv := kvstore.New()
+	kv.Set(fmt.Sprintf("index-submitted-%s-%s", submittedAt.UTC().Format(submittedLayout), id), id)
+	indexReceiptFields(id, receipt)
+}
+
+// indexReceiptFields adds a receipt to the retailer, purchase date and total indexes.
+func indexReceiptFields(id string, receipt *pb.Receipt) {
+	kv := kvstore.New()
+	for _, key := range fieldIndexKeys(id, receipt) {
+		kv.Set(key, id)
+	}
+}
+
+// unindexReceiptFields removes a receipt from the field indexes, before it is replaced.
+func unindexReceiptFields(id string, receipt *pb.Receipt) {
+	kv := kvstore.New()
+	for _, key := range fieldIndexKeys(id, receipt) {
+		kv.Delete(key)
+	}
+}
+
+func fieldIndexKeys(id string, receipt *pb.Receipt) []string {
+	keys := []string{
+		fmt.Sprintf("index-retailer-%s-%s", retailerKey(receipt.Retailer), id),
+		fmt.Sprintf("index-date-%s-%s", receipt.PurchaseDate, id),
+	}
+	if parsed, err := parseReceipt(receipt); err == nil {
+		keys = append(keys, fmt.Sprintf("index-total-%015d-%s", parsed.Total.Cents(), id))
+	}
+	return keys
+}
+
+// indexStatus moves a receipt from its old status index to its new one.
+func indexStatus(id string, oldStatus string, newStatus string) {
+	kv := kvstore.New()
+	if oldStatus != "" {
+		kv.Delete(fmt.Sprintf("index-status-%s-%s", oldStatus, id))
+	}
+	kv.Set(fmt.Sprintf("index-status-%s-%s", newStatus, id), id)
+}
+
+// indexPoints records the points of a scored receipt, replacing the points it had before.
+func indexPoints(id string, points int) {
+	kv := kvstore.New()
+	if previous, err := kv.Get(fmt.Sprintf("receipt-%s", id)); err == nil {
+		var old int
+		if _, err := fmt.Sscanf(previous, "%d", &old); err == nil {
+			kv.Delete(fmt.Sprintf("index-points-%010d-%s", old, id))
+		}
+	}
+	kv.Set(fmt.Sprintf("index-points-%010d-%s", points, id), id)
+}
+
+// retailerKey hashes the canonical retailer name, so names with dashes can't run into the ID.
+func retailerKey(retailer string) string {
+	sum := sha256.Sum256([]byte(canonicalName(retailer)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// indexedIDs returns the receipt IDs stored under an index prefix.
+func indexedIDs(prefix string) map[string]bool {
+	kv := kvstore.New()
+	ids := map[string]bool{}
+	for _, key := range kv.Keys(prefix) {
+		if id, err := kv.Get(key); err == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// intersect narrows a candidate set, where nil means every receipt.
+func intersect(candidates map[string]bool, ids map[string]bool) map[string]bool {
+	if candidates == nil {
+		return ids
+	}
+	for id := range candidates {
+		if !ids[id] {
+			delete(candidates, id)
+		}
+	}
+	return candidates
+}
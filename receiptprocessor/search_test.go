@@ -0,0 +1,99 @@
+package receiptprocessor
+
+import (
+	"testing"
+)
+
+func searchTotals(t *testing.T, query ReceiptQuery) ([]string, string) {
+	t.Helper()
+	page, err := SearchReceipts(query)
+	if err != nil {
+		t.Fatalf("could not search receipts: %v", err)
+	}
+	totals := []string{}
+	for _, receipt := range page.Receipts {
+		totals = append(totals, receipt.Receipt.Total)
+	}
+	return totals, page.NextCursor
+}
+
+func expectTotals(t *testing.T, totals []string, expected ...string) {
+	t.Helper()
+	if len(totals) != len(expected) {
+		t.Fatalf("expected totals %v, got %v", expected, totals)
+	}
+	for i := range expected {
+		if totals[i] != expected[i] {
+			t.Errorf("expected totals %v, got %v", expected, totals)
+			return
+		}
+	}
+}
+
+func TestSearchReceipts(t *testing.T) {
+	for i, total := range []string{"7.50", "3.25", "12.75"} {
+		receipt := fraudReceipt("Search  MART", "10:00", total)
+		receipt.PurchaseDate = []string{"2021-03-02", "2021-03-01", "2021-03-03"}[i]
+		if _, err := ProcessReceipt(receipt); err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+	}
+	other := fraudReceipt("Search Market", "10:00", "5.00")
+	if _, err := ProcessReceipt(other); err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+
+	t.Run("Retailer", func(t *testing.T) {
+		totals, _ := searchTotals(t, ReceiptQuery{Retailer: "search mart"})
+		expectTotals(t, totals, "7.50", "3.25", "12.75")
+	})
+
+	t.Run("SortByTotal", func(t *testing.T) {
+		totals, _ := searchTotals(t, ReceiptQuery{Retailer: "Search Mart", SortBy: SortByTotal, Descending: true})
+		expectTotals(t, totals, "12.75", "7.50", "3.25")
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		minTotal, minPoints := Money(500), 1
+		totals, _ := searchTotals(t, ReceiptQuery{
+			Retailer:      "Search Mart",
+			PurchasedFrom: "2021-03-02",
+			MinTotal:      &minTotal,
+			MinPoints:     &minPoints,
+			Status:        StatusScored,
+			SortBy:        SortByPurchaseDate,
+		})
+		expectTotals(t, totals, "7.50", "12.75")
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		query := ReceiptQuery{Retailer: "Search Mart", SortBy: SortByPurchaseDate, Limit: 2}
+		totals, cursor := searchTotals(t, query)
+		expectTotals(t, totals, "3.25", "7.50")
+		if cursor == "" {
+			t.Fatalf("expected a cursor for the next page")
+		}
+		query.Cursor = cursor
+		totals, cursor = searchTotals(t, query)
+		expectTotals(t, totals, "12.75")
+		if cursor != "" {
+			t.Errorf("expected no cursor after the last page, got %q", cursor)
+		}
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		if _, err := SearchReceipts(ReceiptQuery{SortBy: SortByTotal, Cursor: "not-a-cursor"}); err != ErrInvalidCursor {
+			t.Errorf("expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
+	t.Run("StatusIndex", func(t *testing.T) {
+		page, err := SearchReceipts(ReceiptQuery{Retailer: "Search Market", Status: StatusReceived})
+		if err != nil {
+			t.Fatalf("could not search receipts: %v", err)
+		}
+		if len(page.Receipts) != 0 {
+			t.Errorf("expected a scored receipt to have left the received index, got %v", page.Receipts)
+		}
+	})
+}
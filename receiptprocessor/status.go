@@ -0,0 +1,68 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Lifecycle statuses of a receipt. A receipt is received, validated against the soft checks,
+// then either held for review or processed, and ends up scored, rejected or voided. A receipt
+// whose scoring keeps failing is marked failed until it is requeued from the dead-letter list.
+const (
+	StatusReceived      = "received"
+	StatusValidating    = "validating"
+	StatusPendingReview = ReviewPending
+	StatusProcessing    = "processing"
+	StatusScored        = "scored"
+	StatusRejected      = "rejected"
+	StatusVoided        = "voided"
+	StatusFailed        = "failed"
+)
+
+// statusMu serializes the read-modify-write of a receipt's status history.
+var statusMu sync.Mutex
+
+// setStatus moves a receipt to a new status and records when, and optionally why, it happened.
+func setStatus(id string, status string, reason string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	receiptStatus, err := GetReceiptStatus(id)
+	if err != nil {
+		receiptStatus = &pb.ReceiptStatus{Id: id}
+	}
+	indexStatus(id, receiptStatus.Status, status)
+	receiptStatus.Status = status
+	receiptStatus.History = append(receiptStatus.History, &pb.StatusTransition{
+		Status: status,
+		At:     now().UTC().Format(time.RFC3339Nano),
+		Reason: reason,
+	})
+
+	data, err := protojson.Marshal(receiptStatus)
+	if err != nil {
+		fmt.Printf("Error storing status of receipt %s: %v\n", id, err)
+		return
+	}
+	kv := kvstore.New()
+	kv.Set(fmt.Sprintf("receipt-%s-status", id), string(data))
+}
+
+// GetReceiptStatus returns the current status of a receipt and every transition it went through.
+func GetReceiptStatus(id string) (*pb.ReceiptStatus, error) {
+	kv := kvstore.New()
+	data, err := kv.Get(fmt.Sprintf("receipt-%s-status", id))
+	if err != nil {
+		return nil, err
+	}
+	receiptStatus := &pb.ReceiptStatus{}
+	if err := protojson.Unmarshal([]byte(data), receiptStatus); err != nil {
+		return nil, err
+	}
+	return receiptStatus, nil
+}
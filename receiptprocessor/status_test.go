@@ -0,0 +1,64 @@
+package receiptprocessor
+
+import (
+	"testing"
+)
+
+func statusHistory(t *testing.T, id string) []string {
+	t.Helper()
+	status, err := GetReceiptStatus(id)
+	if err != nil {
+		t.Fatalf("could not get status: %v", err)
+	}
+	history := []string{}
+	for _, transition := range status.History {
+		if transition.At == "" {
+			t.Errorf("expected every transition to have a timestamp, got %v", transition)
+		}
+		history = append(history, transition.Status)
+	}
+	if status.Status != history[len(history)-1] {
+		t.Errorf("expected the status %s to be the last transition, got %v", status.Status, history)
+	}
+	return history
+}
+
+func expectHistory(t *testing.T, history []string, expected ...string) {
+	t.Helper()
+	if len(history) != len(expected) {
+		t.Fatalf("expected history %v, got %v", expected, history)
+	}
+	for i := range expected {
+		if history[i] != expected[i] {
+			t.Errorf("expected history %v, got %v", expected, history)
+			return
+		}
+	}
+}
+
+func TestReceiptStatus(t *testing.T) {
+	resetStore()
+	t.Run("Scored", func(t *testing.T) {
+		id, err := ProcessReceipt(fraudReceipt("Status Shop", "09:30", "7.00"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		expectHistory(t, statusHistory(t, id), StatusReceived, StatusValidating, StatusProcessing, StatusScored)
+	})
+
+	t.Run("HeldThenApproved", func(t *testing.T) {
+		id := holdReceipt(t, "Status Deli")
+		expectHistory(t, statusHistory(t, id), StatusReceived, StatusValidating, StatusPendingReview)
+
+		if _, err := ApproveReceipt(id); err != nil {
+			t.Fatalf("could not approve receipt: %v", err)
+		}
+		expectHistory(t, statusHistory(t, id), StatusReceived, StatusValidating, StatusPendingReview, StatusProcessing, StatusScored)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := GetReceiptStatus("missing"); err == nil {
+			t.Errorf("expected an error for a receipt that doesn't exist")
+		}
+	})
+}
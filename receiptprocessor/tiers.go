@@ -0,0 +1,274 @@
+package receiptprocessor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// A user's tier is decided by the points they earned on receipts over the last 12 months, net of
+// voids and corrections; points spent or expired still count. The points are added up per day under
+// "earned-<hash of user ID>-<date>" as entries are posted, so the window is counted in whole days.
+// Once a receipt is scored, the multiplier of the submitter's tier is applied to the total as a
+// final "tier" stage. The tier is kept with its history of changes under "tier-<hash of user ID>",
+// and re-evaluated whenever the user's points change and by RefreshTiers as old points fall out of
+// the window.
+
+// Tier is a loyalty level.
+type Tier struct {
+	Name string `json:"name"`
+	// MinPoints is the points earned in the last 12 months needed to reach the tier.
+	MinPoints int `json:"minPoints"`
+	// Multiplier scales the points of receipts scored while in the tier, with up to 2 decimals, e.g. "1.25".
+	Multiplier string `json:"multiplier"`
+
+	// hundredths is the multiplier times 100
+	hundredths int
+}
+
+// DefaultTiers are Bronze from the first point, Silver from 5000 points and Gold from 15000.
+var DefaultTiers = []Tier{
+	{Name: "Bronze", MinPoints: 0, Multiplier: "1", hundredths: 100},
+	{Name: "Silver", MinPoints: 5000, Multiplier: "1.25", hundredths: 125},
+	{Name: "Gold", MinPoints: 15000, Multiplier: "1.5", hundredths: 150},
+}
+
+var (
+	tierMu sync.RWMutex
+	tiers  = DefaultTiers
+	// tierRecordMu keeps a user's tier and its history in step.
+	tierRecordMu sync.Mutex
+)
+
+var multiplierPattern = regexp.MustCompile(`^\d+(\.\d{1,2})?$`)
+
+// SetTiers validates and activates the tiers. They must start at 0 points and be listed in order
+// of increasing points. Changing them doesn't move anyone until their tier is next re-evaluated.
+func SetTiers(levels []Tier) error {
+	if len(levels) == 0 {
+		return fmt.Errorf("at least one tier is required")
+	}
+	names := map[string]bool{}
+	validated := make([]Tier, len(levels))
+	for i, tier := range levels {
+		if tier.Name == "" || names[tier.Name] {
+			return fmt.Errorf("tier %d: the name must be set and unique", i+1)
+		}
+		names[tier.Name] = true
+		if i == 0 && tier.MinPoints != 0 {
+			return fmt.Errorf("tier %s: the first tier must start at 0 points", tier.Name)
+		}
+		if i > 0 && tier.MinPoints <= levels[i-1].MinPoints {
+			return fmt.Errorf("tier %s: must need more points than %s", tier.Name, levels[i-1].Name)
+		}
+		hundredths, err := parseMultiplier(tier.Multiplier)
+		if err != nil {
+			return fmt.Errorf("tier %s: %w", tier.Name, err)
+		}
+		tier.hundredths = hundredths
+		validated[i] = tier
+	}
+	tierMu.Lock()
+	defer tierMu.Unlock()
+	tiers = validated
+	return nil
+}
+
+func parseMultiplier(multiplier string) (int, error) {
+	if !multiplierPattern.MatchString(multiplier) {
+		return 0, fmt.Errorf("invalid multiplier %q", multiplier)
+	}
+	whole, fraction, _ := strings.Cut(multiplier, ".")
+	fraction = (fraction + "00")[:2]
+	hundredths, err := strconv.Atoi(whole + fraction)
+	if err != nil || hundredths < 100 {
+		return 0, fmt.Errorf("invalid multiplier %q, it must be at least 1", multiplier)
+	}
+	return hundredths, nil
+}
+
+// tierFor returns the highest tier a number of points reaches, and the tier after it if there is one.
+func tierFor(points int) (Tier, *Tier) {
+	tierMu.RLock()
+	defer tierMu.RUnlock()
+	current := 0
+	for i, tier := range tiers {
+		if points >= tier.MinPoints {
+			current = i
+		}
+	}
+	if current+1 < len(tiers) {
+		return tiers[current], &tiers[current+1]
+	}
+	return tiers[current], nil
+}
+
+// earnedPrefix is the start of the keys of a user's daily earned points, which end with the date.
+func earnedPrefix(userID string) string {
+	return fmt.Sprintf("earned-%s-", userKey(userID))
+}
+
+// recordEarnedPoints adds the points a ledger entry gives to, or takes back from, a user for a
+// receipt to the user's total for the day. Voids and corrections count on the day the receipt was
+// first awarded, so they only change the window its points are in, and are dropped if that day
+// has already left the window.
+func recordEarnedPoints(entry *pb.LedgerEntry) {
+	if entry.UserId == "" || entry.ReceiptId == "" {
+		return
+	}
+	points := int(entry.Amount)
+	if entry.Credit == AccountPointsIssued {
+		points = -points
+	} else if entry.Debit != AccountPointsIssued {
+		return
+	}
+	postedAt, err := time.Parse(time.RFC3339Nano, entry.At)
+	if err != nil {
+		return
+	}
+	earnedAt := postedAt
+	if entry.Kind != EntryAward {
+		if entries := receiptLedger(entry.ReceiptId); len(entries) > 0 {
+			if awardedAt, err := time.Parse(time.RFC3339Nano, entries[0].At); err == nil {
+				earnedAt = awardedAt
+			}
+		}
+		if earnedAt.Before(postedAt.AddDate(-1, 0, 0)) {
+			return
+		}
+	}
+	kv := kvstore.New()
+	kv.Increment(earnedPrefix(entry.UserId)+earnedAt.UTC().Format(time.DateOnly), points)
+}
+
+// rollingPoints returns the points a user earned on receipts in the 12 months up to a day.
+func rollingPoints(userID string, at time.Time) int {
+	kv := kvstore.New()
+	prefix := earnedPrefix(userID)
+	since := at.UTC().AddDate(-1, 0, 0)
+	day := time.Date(since.Year(), since.Month(), since.Day()+1, 0, 0, 0, 0, time.UTC)
+	points := 0
+	for ; !day.After(at); day = day.AddDate(0, 0, 1) {
+		if data, err := kv.Get(prefix + day.Format(time.DateOnly)); err == nil {
+			earned, _ := strconv.Atoi(data)
+			points += earned
+		}
+	}
+	return points
+}
+
+// applyTier multiplies the total of a scored receipt, adding the extra points as a "tier" stage.
+func applyTier(breakdown *pb.ScoreBreakdown, tier Tier) {
+	extra := int(breakdown.Total)*tier.hundredths/100 - int(breakdown.Total)
+	breakdown.Total += int32(extra)
+	breakdown.Tier = tier.Name
+	breakdown.TierMultiplier = tier.Multiplier
+	breakdown.Stages = append(breakdown.Stages, &pb.StageBreakdown{
+		Stage:    "tier",
+		Rules:    []*pb.RuleResult{{Rule: fmt.Sprintf("%s x%s", tier.Name, tier.Multiplier), Points: int32(extra)}},
+		Subtotal: breakdown.Total,
+	})
+}
+
+// applyUserTier applies the multiplier of the tier a user is in now. Anonymous receipts have no tier.
+func applyUserTier(breakdown *pb.ScoreBreakdown, userID string) {
+	if userID == "" {
+		return
+	}
+	tier, _ := tierFor(rollingPoints(userID, now()))
+	applyTier(breakdown, tier)
+}
+
+// refreshTier re-evaluates a user's tier at a time, recording a change in its history.
+func refreshTier(userID string, at time.Time) *pb.UserTier {
+	tierRecordMu.Lock()
+	defer tierRecordMu.Unlock()
+
+	record, changed := evaluateTier(userID, at)
+	if changed {
+		saveTier(userID, record)
+	}
+	return record
+}
+
+// evaluateTier works out a user's tier at a time, adding a change to the history of their stored
+// record if they moved, and reports whether they did.
+func evaluateTier(userID string, at time.Time) (*pb.UserTier, bool) {
+	points := rollingPoints(userID, at)
+	tier, next := tierFor(points)
+	record := loadTier(userID)
+	if record.Tier == "" {
+		lowest, _ := tierFor(0)
+		record.Tier = lowest.Name
+	}
+	changed := record.Tier != tier.Name
+	if changed {
+		record.History = append(record.History, &pb.TierChange{
+			From:   record.Tier,
+			To:     tier.Name,
+			Points: int32(points),
+			At:     at.UTC().Format(time.RFC3339Nano),
+		})
+		record.Tier = tier.Name
+	}
+
+	record.Multiplier = tier.Multiplier
+	record.Points = int32(points)
+	if next != nil {
+		record.NextTier = next.Name
+		record.PointsToNextTier = int32(next.MinPoints - points)
+	}
+	return record, changed
+}
+
+// GetUserTier returns a user's tier, the points it is based on, what it takes to reach the next one
+// and every change of tier so far. It doesn't store anything; a move that is due is recorded by the
+// next award or sweep.
+func GetUserTier(userID string) *pb.UserTier {
+	tierRecordMu.Lock()
+	defer tierRecordMu.Unlock()
+	record, _ := evaluateTier(userID, now())
+	return record
+}
+
+// RefreshTiers re-evaluates the tier of every user who has left the lowest tier, moving down those
+// whose points from more than 12 months ago no longer count.
+func RefreshTiers(at time.Time) {
+	kv := kvstore.New()
+	for _, key := range kv.Keys("tier-") {
+		data, err := kv.Get(key)
+		if err != nil {
+			continue
+		}
+		record := &pb.UserTier{}
+		if err := protojson.Unmarshal([]byte(data), record); err == nil {
+			refreshTier(record.UserId, at)
+		}
+	}
+}
+
+func loadTier(userID string) *pb.UserTier {
+	kv := kvstore.New()
+	record := &pb.UserTier{UserId: userID}
+	if data, err := kv.Get(fmt.Sprintf("tier-%s", userKey(userID))); err == nil {
+		if err := protojson.Unmarshal([]byte(data), record); err != nil {
+			fmt.Printf("Error reading tier of user %s: %v\n", userID, err)
+		}
+	}
+	return record
+}
+
+func saveTier(userID string, record *pb.UserTier) {
+	kv := kvstore.New()
+	stored := &pb.UserTier{UserId: userID, Tier: record.Tier, History: record.History}
+	if data, err := protojson.Marshal(stored); err == nil {
+		kv.Set(fmt.Sprintf("tier-%s", userKey(userID)), string(data))
+	}
+}
@@ -0,0 +1,127 @@
+package receiptprocessor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTiers(t *testing.T) {
+	resetStore()
+	if err := SetTiers([]Tier{
+		{Name: "Bronze", MinPoints: 0, Multiplier: "1"},
+		{Name: "Silver", MinPoints: 10, Multiplier: "2"},
+		{Name: "Gold", MinPoints: 100000, Multiplier: "3"},
+	}); err != nil {
+		t.Fatalf("could not set tiers: %v", err)
+	}
+	defer SetTiers(DefaultTiers)
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, levels := range map[string][]Tier{
+			"empty":       {},
+			"not at zero": {{Name: "Bronze", MinPoints: 5, Multiplier: "1"}},
+			"out of order": {
+				{Name: "Bronze", MinPoints: 0, Multiplier: "1"},
+				{Name: "Silver", MinPoints: 0, Multiplier: "1.5"},
+			},
+			"below one":    {{Name: "Bronze", MinPoints: 0, Multiplier: "0.5"}},
+			"too precise":  {{Name: "Bronze", MinPoints: 0, Multiplier: "1.125"}},
+			"missing name": {{MinPoints: 0, Multiplier: "1"}},
+		} {
+			if err := SetTiers(levels); err == nil {
+				t.Errorf("expected an error for tiers that are %s", name)
+			}
+		}
+	})
+
+	awardedAt := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return awardedAt }
+	defer func() { now = time.Now }()
+	first, err := ProcessReceiptForUser("tier-user", fraudReceipt("Tier Tailors", "14:00", "7.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	earned := storedPoints(first)
+
+	t.Run("Promoted", func(t *testing.T) {
+		tier := GetUserTier("tier-user")
+		if tier.Tier != "Silver" || int(tier.Points) != earned || tier.NextTier != "Gold" {
+			t.Fatalf("expected Silver with %d points, got %v", earned, tier)
+		}
+		if len(tier.History) != 1 || tier.History[0].From != "Bronze" || tier.History[0].To != "Silver" {
+			t.Errorf("expected a promotion from Bronze to Silver, got %v", tier.History)
+		}
+	})
+
+	t.Run("Multiplier", func(t *testing.T) {
+		second, err := ProcessReceiptForUser("tier-user", fraudReceipt("Tier Toys", "14:30", "3.10"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		breakdown, err := GetScoreBreakdown(second)
+		if err != nil {
+			t.Fatalf("could not get breakdown: %v", err)
+		}
+		last := breakdown.Stages[len(breakdown.Stages)-1]
+		base := int(breakdown.Stages[len(breakdown.Stages)-2].Subtotal)
+		if breakdown.Tier != "Silver" || last.Stage != "tier" || int(breakdown.Total) != base*2 || storedPoints(second) != base*2 {
+			t.Errorf("expected Silver to double %d points, got %v", base, breakdown)
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		now = func() time.Time { return awardedAt.AddDate(1, 0, 1) }
+		tier := GetUserTier("tier-user")
+		now = func() time.Time { return awardedAt }
+		if tier.Tier != "Bronze" || tier.Points != 0 {
+			t.Errorf("expected Bronze once the points are a year old, got %v", tier)
+		}
+		if stored := loadTier("tier-user"); stored.Tier != "Silver" || len(stored.History) != 1 {
+			t.Errorf("expected reading the tier to leave it stored as Silver, got %v", stored)
+		}
+	})
+
+	t.Run("Demoted", func(t *testing.T) {
+		RefreshTiers(awardedAt.AddDate(1, 0, 1))
+		tier := loadTier("tier-user")
+		if tier.Tier != "Bronze" || len(tier.History) != 2 || tier.History[1].Points != 0 {
+			t.Errorf("expected a move back to Bronze once the points are a year old, got %v", tier)
+		}
+	})
+
+	t.Run("VoidOldAward", func(t *testing.T) {
+		now = func() time.Time { return awardedAt.AddDate(0, -13, 0) }
+		old, err := ProcessReceiptForUser("tier-voider", fraudReceipt("Tier Trunks", "09:00", "5.10"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		now = func() time.Time { return awardedAt.AddDate(0, -2, 0) }
+		recent, err := ProcessReceiptForUser("tier-voider", fraudReceipt("Tier Tents", "09:00", "6.10"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		now = func() time.Time { return awardedAt }
+		if _, err := VoidReceipt(old, "refunded"); err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		if points := rollingPoints("tier-voider", awardedAt); points != storedPoints(recent) {
+			t.Errorf("expected voiding points from outside the window to leave %d points, got %d", storedPoints(recent), points)
+		}
+		if _, err := VoidReceipt(recent, "refunded"); err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		if points := rollingPoints("tier-voider", awardedAt.AddDate(0, 10, 15)); points != 0 {
+			t.Errorf("expected the void to leave the window with the award, got %d points", points)
+		}
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		id, err := ProcessReceipt(fraudReceipt("Tier Tavern", "15:00", "4.10"))
+		if err != nil {
+			t.Fatalf("could not process receipt: %v", err)
+		}
+		if breakdown, err := GetScoreBreakdown(id); err != nil || breakdown.Tier != "" {
+			t.Errorf("expected no tier for an anonymous receipt, got %v", breakdown)
+		}
+	})
+}
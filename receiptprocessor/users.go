@@ -0,0 +1,49 @@
+package receiptprocessor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+	"github.com/keith-decker/fetch-assignment/pb"
+)
+
+// A receipt submitted for a user is recorded under "receipt-<ID>-user" and indexed under
+// "index-user-<hash of user ID>-<receipt ID>". Its points are posted to the user's ledger account.
+
+// assignUser records who submitted a receipt.
+func assignUser(id string, userID string) {
+	if userID == "" {
+		return
+	}
+	kv := kvstore.New()
+	kv.Set(fmt.Sprintf("receipt-%s-user", id), userID)
+	kv.Set(fmt.Sprintf("index-user-%s-%s", userKey(userID), id), id)
+}
+
+// receiptUser returns the user who submitted a receipt, or "" if it was submitted anonymously.
+func receiptUser(id string) string {
+	kv := kvstore.New()
+	userID, err := kv.Get(fmt.Sprintf("receipt-%s-user", id))
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// GetUserBalance returns the points a user has earned and how many receipts they submitted.
+// A user who never submitted a receipt has a balance of 0.
+func GetUserBalance(userID string) *pb.UserBalance {
+	kv := kvstore.New()
+	balance := &pb.UserBalance{UserId: userID}
+	balance.Points = int32(AccountBalance(userAccount(userID)))
+	balance.Receipts = int32(len(kv.Keys(fmt.Sprintf("index-user-%s-", userKey(userID)))))
+	return balance
+}
+
+// userKey hashes a user ID for the index, so IDs with dashes can't run into the receipt ID.
+func userKey(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,52 @@
+package receiptprocessor
+
+import (
+	"testing"
+)
+
+func TestUserBalance(t *testing.T) {
+	resetStore()
+	first, err := ProcessReceiptForUser("balance-user", fraudReceipt("Balance Books", "09:00", "3.10"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	second, err := ProcessReceiptForUser("balance-user", fraudReceipt("Balance Bakery", "09:30", "2.20"))
+	if err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+	if _, err := ProcessReceiptForUser("other-user", fraudReceipt("Balance Butcher", "10:00", "9.30")); err != nil {
+		t.Fatalf("could not process receipt: %v", err)
+	}
+
+	expected := storedPoints(first) + storedPoints(second)
+	balance := GetUserBalance("balance-user")
+	if int(balance.Points) != expected || balance.Receipts != 2 {
+		t.Errorf("expected %d points from 2 receipts, got %v", expected, balance)
+	}
+
+	t.Run("Void", func(t *testing.T) {
+		voided := storedPoints(first)
+		if _, err := VoidReceipt(first, "refunded"); err != nil {
+			t.Fatalf("could not void receipt: %v", err)
+		}
+		if balance := GetUserBalance("balance-user"); int(balance.Points) != expected-voided {
+			t.Errorf("expected the void to take %d points back, got %v", voided, balance)
+		}
+	})
+
+	t.Run("Receipts", func(t *testing.T) {
+		page, err := SearchReceipts(ReceiptQuery{UserID: "balance-user"})
+		if err != nil {
+			t.Fatalf("could not search receipts: %v", err)
+		}
+		if len(page.Receipts) != 2 || page.Receipts[0].Id != first || page.Receipts[1].UserId != "balance-user" {
+			t.Errorf("expected the user's two receipts, got %v", page.Receipts)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		if balance := GetUserBalance("nobody"); balance.Points != 0 || balance.Receipts != 0 {
+			t.Errorf("expected an empty balance, got %v", balance)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package receiptprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/keith-decker/fetch-assignment/openapi"
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Codes for the kind of problem found with a field. The schema codes come from the openapi package.
+const (
+	CodeRequired    = openapi.CodeRequired
+	CodeInvalid     = openapi.CodeInvalid
+	CodeMinItems    = openapi.CodeMinItems
+	CodeMaxItems    = openapi.CodeMaxItems
+	CodeUnsupported = "unsupported"
+	CodeMalformed   = "malformed"
+)
+
+// FieldError describes one problem with a receipt field. Path is a JSON path into the
+// submitted receipt, e.g. "$.items[2].price".
+type FieldError struct {
+	Path    string
+	Code    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors is every problem found with a receipt. An empty list means the receipt is valid.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, err := range v {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v ValidationErrors) has(path string) bool {
+	for _, err := range v {
+		if err.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *ValidationErrors) add(path string, code string, format string, args ...any) {
+	*v = append(*v, FieldError{Path: path, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+var (
+	schemaMu      sync.RWMutex
+	receiptSchema *openapi.Schema
+)
+
+// SetReceiptSchema sets the schema, loaded from the OpenAPI document, that receipts are
+// validated against. Until one is set every receipt is rejected, rather than only getting the
+// checks the spec can't express.
+func SetReceiptSchema(schema *openapi.Schema) {
+	schemaMu.Lock()
+	receiptSchema = schema
+	schemaMu.Unlock()
+}
+
+// ValidateReceiptJSON validates a receipt exactly as the client sent it, so every field and
+// pattern in the schema is enforced on the request itself. It then decodes and normalizes the
+// receipt and runs the same checks as ValidateReceiptWithWarnings. The receipt is nil when it
+// couldn't be decoded.
+func ValidateReceiptJSON(data []byte) (receipt *pb.Receipt, errs ValidationErrors, warnings ValidationErrors) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, ValidationErrors{{Path: "$", Code: CodeMalformed, Message: err.Error()}}, nil
+	}
+	errs = validateSchemaValue(value)
+
+	receipt = &pb.Receipt{}
+	if err := protojson.Unmarshal(data, receipt); err != nil {
+		// a value of the wrong type is already reported against its field
+		if len(errs) == 0 {
+			errs.add("$", CodeMalformed, "%v", err)
+		}
+		return nil, errs, nil
+	}
+	NormalizeReceipt(receipt)
+	errs, warnings = validateFields(receipt, errs)
+	return receipt, errs, warnings
+}
+
+// ValidateReceipt checks every field of the receipt and returns the problems it finds.
+func ValidateReceipt(receipt *pb.Receipt) ValidationErrors {
+	errs, _ := ValidateReceiptWithWarnings(receipt)
+	return errs
+}
+
+// ValidateReceiptWithWarnings also returns the consistency problems that the policy
+// reports as warnings rather than rejecting the receipt. The schema is checked against the
+// receipt as protojson writes it, which leaves out empty fields; prefer ValidateReceiptJSON
+// when the request body is at hand.
+func ValidateReceiptWithWarnings(receipt *pb.Receipt) (errs ValidationErrors, warnings ValidationErrors) {
+	return validateFields(receipt, validateSchema(receipt))
+}
+
+// validateFields runs the checks the schema can't express, after the schema errors found so far.
+func validateFields(receipt *pb.Receipt, errs ValidationErrors) (ValidationErrors, ValidationErrors) {
+	validateNames(receipt, &errs)
+
+	currency := validateCurrency(receipt, &errs)
+	validateAmounts(receipt, currency, &errs)
+
+	// the cross-field checks need every field to parse
+	if len(errs) > 0 {
+		return errs, nil
+	}
+	parsed, err := parseReceipt(receipt)
+	if err != nil {
+		errs.add("$", CodeInvalid, "%v", err)
+		return errs, nil
+	}
+	consistencyErrs, warnings := checkConsistency(parsed)
+	return append(errs, consistencyErrs...), warnings
+}
+
+// validateSchema checks a decoded receipt against the OpenAPI schema.
+func validateSchema(receipt *pb.Receipt) ValidationErrors {
+	// empty strings are left out, so a blank field shows up as missing
+	data, err := protojson.Marshal(receipt)
+	if err != nil {
+		return ValidationErrors{{Path: "$", Code: CodeMalformed, Message: err.Error()}}
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return ValidationErrors{{Path: "$", Code: CodeMalformed, Message: err.Error()}}
+	}
+	return validateSchemaValue(value)
+}
+
+// validateSchemaValue checks a JSON value against the OpenAPI schema. Without a schema nothing passes.
+func validateSchemaValue(value any) ValidationErrors {
+	errs := ValidationErrors{}
+
+	schemaMu.RLock()
+	schema := receiptSchema
+	schemaMu.RUnlock()
+	if schema == nil {
+		errs.add("$", CodeUnsupported, "receipts can't be validated until a schema is set")
+		return errs
+	}
+
+	for _, err := range schema.Validate(value) {
+		errs = append(errs, FieldError{Path: err.Path, Code: err.Code, Message: err.Message})
+	}
+	return errs
+}
+
+// validateCurrency returns the receipt currency, or "" if it cannot be used to check amounts.
+func validateCurrency(receipt *pb.Receipt, errs *ValidationErrors) string {
+	currency, err := receiptCurrency(receipt.Currency)
+	if err != nil {
+		errs.add("$.currency", CodeUnsupported, "%v", err)
+		return ""
+	}
+	return currency
+}
+
+// validateAmounts checks the amounts use the right number of decimals for the currency,
+// which the spec can only describe loosely.
+func validateAmounts(receipt *pb.Receipt, currency string, errs *ValidationErrors) {
+	if currency == "" {
+		return
+	}
+	// don't repeat a field the schema already rejected
+	if receipt.Total != "" && !errs.has("$.total") {
+		if _, err := parseAmount(receipt.Total, currency); err != nil {
+			errs.add("$.total", CodeInvalid, "total must be an amount with %d decimals for %s", minorUnits[currency], currency)
+		}
+	}
+	for i, item := range receipt.Items {
+		path := fmt.Sprintf("$.items[%d].price", i)
+		if item.Price == "" || errs.has(path) {
+			continue
+		}
+		if _, err := parseAmount(item.Price, currency); err != nil {
+			errs.add(path, CodeInvalid, "price must be an amount with %d decimals for %s", minorUnits[currency], currency)
+		}
+	}
+}
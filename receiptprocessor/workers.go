@@ -0,0 +1,148 @@
+package receiptprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/keith-decker/fetch-assignment/kvstore"
+)
+
+// ErrQueueFull is returned when every slot in the scoring queue is taken. Nothing is stored
+// for the receipt, so it can be submitted again later.
+var ErrQueueFull = errors.New("the scoring queue is full")
+
+var (
+	workerMu sync.RWMutex
+	// jobs carries the IDs of receipts to score, their job records are in the store
+	jobs chan string
+	// slots has one entry per receipt that is queued, being scored or waiting to be retried
+	slots   chan struct{}
+	workers sync.WaitGroup
+)
+
+// StartWorkers scores receipts in the background on count goroutines, with room for queueSize
+// receipts waiting or being scored. Until it is called, receipts are scored on the caller's goroutine.
+//
+// Jobs left in the store by a previous run, e.g. one that crashed, are queued again.
+func StartWorkers(count int, queueSize int) error {
+	if count < 1 || queueSize < 1 {
+		return fmt.Errorf("workers and queue size must be at least 1")
+	}
+	workerMu.Lock()
+	defer workerMu.Unlock()
+	if jobs != nil {
+		return fmt.Errorf("workers are already running")
+	}
+
+	jobs = make(chan string, queueSize)
+	slots = make(chan struct{}, queueSize)
+	for i := 0; i < count; i++ {
+		workers.Add(1)
+		go work(jobs)
+	}
+	go requeueStoredJobs(slots)
+	return nil
+}
+
+// StopWorkers waits for the queued receipts to be scored and goes back to scoring inline.
+// Retries that are still waiting for their backoff run inline when it expires.
+func StopWorkers() {
+	workerMu.Lock()
+	if jobs == nil {
+		workerMu.Unlock()
+		return
+	}
+	close(jobs)
+	jobs = nil
+	workerMu.Unlock()
+
+	workers.Wait()
+	workerMu.Lock()
+	slots = nil
+	workerMu.Unlock()
+}
+
+func work(queue <-chan string) {
+	defer workers.Done()
+	for id := range queue {
+		runJob(id)
+	}
+}
+
+// reserveSlot makes room in the queue for one receipt, or returns ErrQueueFull.
+func reserveSlot() error {
+	workerMu.RLock()
+	defer workerMu.RUnlock()
+	if slots == nil {
+		return nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// waitForSlot is like reserveSlot, but waits for a worker to free a slot until ctx is done.
+func waitForSlot(ctx context.Context) error {
+	workerMu.RLock()
+	available := slots
+	workerMu.RUnlock()
+	if available == nil {
+		return nil
+	}
+	select {
+	case available <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrQueueFull, ctx.Err())
+	}
+}
+
+// releaseSlot gives back a slot, either when a receipt is done or when it turned out not to need scoring.
+func releaseSlot() {
+	workerMu.RLock()
+	defer workerMu.RUnlock()
+	if slots == nil {
+		return
+	}
+	select {
+	case <-slots:
+	default:
+	}
+}
+
+// dispatch hands a receipt, whose slot is already reserved, to the workers, or scores it
+// inline when they aren't running.
+func dispatch(id string) {
+	workerMu.RLock()
+	queue := jobs
+	if queue != nil {
+		// the reserved slot guarantees room, so this never blocks
+		queue <- id
+	}
+	workerMu.RUnlock()
+
+	if queue == nil {
+		runJob(id)
+	}
+}
+
+// requeueStoredJobs dispatches the jobs found in the store at startup, waiting for slots as
+// the workers free them up.
+func requeueStoredJobs(available chan struct{}) {
+	kv := kvstore.New()
+	for _, key := range kv.Keys("job-") {
+		job, err := loadJob(key)
+		if err != nil {
+			continue
+		}
+		available <- struct{}{}
+		fmt.Printf("Requeueing scoring job for receipt %s\n", job.ReceiptId)
+		scheduleJob(strings.TrimPrefix(key, "job-"), job)
+	}
+}
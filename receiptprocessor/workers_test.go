@@ -0,0 +1,57 @@
+package receiptprocessor
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWorkers(t *testing.T) {
+	t.Run("ScoresInBackground", func(t *testing.T) {
+		if err := StartWorkers(2, 10); err != nil {
+			t.Fatalf("could not start workers: %v", err)
+		}
+		ids := []string{}
+		for _, purchaseTime := range []string{"06:00", "07:00", "08:00"} {
+			id, err := ProcessReceipt(fraudReceipt("Worker Mart", purchaseTime, "2.25"))
+			if err != nil {
+				t.Fatalf("could not process receipt: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		StopWorkers()
+
+		for _, id := range ids {
+			if status, err := GetReceiptStatus(id); err != nil || status.Status != StatusScored {
+				t.Errorf("expected %s to be scored once the workers stopped, got %v (%v)", id, status, err)
+			}
+		}
+	})
+
+	t.Run("FullQueue", func(t *testing.T) {
+		if err := StartWorkers(1, 1); err != nil {
+			t.Fatalf("could not start workers: %v", err)
+		}
+		defer StopWorkers()
+
+		// take the only slot, as if a receipt were being scored
+		if err := reserveSlot(); err != nil {
+			t.Fatalf("could not reserve a slot: %v", err)
+		}
+		receipt := fraudReceipt("Full Queue Foods", "09:00", "1.25")
+		if id, err := ProcessReceipt(receipt); !errors.Is(err, ErrQueueFull) || id != "" {
+			t.Errorf("expected ErrQueueFull and no ID, got %q (%v)", id, err)
+		}
+
+		// nothing was stored, so the same receipt is accepted once there is room
+		releaseSlot()
+		if _, err := ProcessReceipt(receipt); err != nil {
+			t.Errorf("expected the receipt to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("RejectsBadSizes", func(t *testing.T) {
+		if err := StartWorkers(0, 10); err == nil {
+			t.Errorf("expected zero workers to be rejected")
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// listRewards returns the rewards catalog. It backs both GET /rewards and GET /admin/rewards.
+func listRewards(w http.ResponseWriter, r *http.Request) {
+	writeReward(w, http.StatusOK, &pb.RewardList{Rewards: receiptprocessor.Rewards()}, nil)
+}
+
+func addReward(w http.ResponseWriter, r *http.Request) {
+	reward := &pb.Reward{}
+	data, err := io.ReadAll(r.Body)
+	if err != nil || protojson.Unmarshal(data, reward) != nil {
+		writeError(w, http.StatusBadRequest, "The request is invalid.")
+		return
+	}
+	added, err := receiptprocessor.AddReward(reward)
+	writeReward(w, http.StatusCreated, added, err)
+}
+
+func updateReward(w http.ResponseWriter, r *http.Request) {
+	reward := &pb.Reward{}
+	data, err := io.ReadAll(r.Body)
+	if err != nil || protojson.Unmarshal(data, reward) != nil {
+		writeError(w, http.StatusBadRequest, "The request is invalid.")
+		return
+	}
+	updated, err := receiptprocessor.UpdateReward(r.PathValue("id"), reward)
+	writeReward(w, http.StatusOK, updated, err)
+}
+
+func removeReward(w http.ResponseWriter, r *http.Request) {
+	if err := receiptprocessor.RemoveReward(r.PathValue("id")); err != nil {
+		writeReward(w, http.StatusOK, nil, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redeemReward spends a user's points on a reward. An Idempotency-Key makes retries safe, so a
+// request that timed out can't spend the points twice.
+func redeemReward(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The request is invalid.")
+		return
+	}
+	idempotent(w, r, data, func(w http.ResponseWriter) {
+		request := &pb.RedemptionRequest{}
+		if protojson.Unmarshal(data, request) != nil || request.RewardId == "" {
+			writeError(w, http.StatusBadRequest, "The request is invalid.")
+			return
+		}
+		redemption, err := receiptprocessor.Redeem(r.PathValue("id"), request.RewardId)
+		writeReward(w, http.StatusCreated, redemption, err)
+	})
+}
+
+func listRedemptions(w http.ResponseWriter, r *http.Request) {
+	redemptions := receiptprocessor.UserRedemptions(r.PathValue("id"))
+	writeReward(w, http.StatusOK, &pb.RedemptionList{Redemptions: redemptions}, nil)
+}
+
+func writeReward(w http.ResponseWriter, status int, message proto.Message, err error) {
+	switch {
+	case errors.Is(err, receiptprocessor.ErrRewardNotFound):
+		writeError(w, http.StatusNotFound, "No reward found for that ID.")
+		return
+	case errors.Is(err, receiptprocessor.ErrInvalidReward):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The reward is invalid: %s.", strings.TrimPrefix(err.Error(), receiptprocessor.ErrInvalidReward.Error()+": ")))
+		return
+	case errors.Is(err, receiptprocessor.ErrRewardExists):
+		writeError(w, http.StatusConflict, "A reward with that ID already exists.")
+		return
+	case errors.Is(err, receiptprocessor.ErrOutOfStock):
+		writeError(w, http.StatusConflict, "This reward is out of stock.")
+		return
+	case errors.Is(err, receiptprocessor.ErrInsufficientPoints):
+		writeError(w, http.StatusConflict, "There are not enough points to redeem this reward.")
+		return
+	case err != nil:
+		log.Print(err)
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := protojson.Marshal(message)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
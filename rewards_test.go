@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestRewardEndpoints(t *testing.T) {
+	resetStore()
+	mux := buildRouter()
+	serve(t, mux, "POST", "/receipts/process", `{"userId":"reward-http","receipt":{"retailer":"Harbor Hardware","purchaseDate":"2022-08-01","purchaseTime":"10:00","items":[{"shortDescription":"Hammer","price":"9.00"}],"total":"9.00"}}`)
+
+	t.Run("Admin", func(t *testing.T) {
+		if rec := serve(t, mux, "POST", "/admin/rewards", `{"id":"http-sticker","name":"Sticker","cost":5,"inventory":2}`); rec.Code != http.StatusCreated {
+			t.Fatalf("expected status 201; got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := serve(t, mux, "POST", "/admin/rewards", `{"id":"http-sticker","name":"Sticker","cost":5}`); rec.Code != http.StatusConflict {
+			t.Errorf("expected status 409 adding a reward twice; got %d", rec.Code)
+		}
+		if rec := serve(t, mux, "POST", "/admin/rewards", `{"name":"Sticker","cost":-1}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for a negative cost; got %d", rec.Code)
+		}
+		if rec := serve(t, mux, "PUT", "/admin/rewards/http-sticker", `{"name":"Sticker","cost":5,"inventory":1}`); rec.Code != http.StatusOK {
+			t.Errorf("expected status 200; got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := serve(t, mux, "PUT", "/admin/rewards/missing", `{"name":"Sticker","cost":5}`); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404; got %d", rec.Code)
+		}
+	})
+
+	t.Run("Catalog", func(t *testing.T) {
+		rec := serve(t, mux, "GET", "/rewards", "")
+		list := &pb.RewardList{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), list); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		found := false
+		for _, reward := range list.Rewards {
+			found = found || reward.Id == "http-sticker"
+		}
+		if !found {
+			t.Errorf("expected the sticker in the catalog, got %v", list.Rewards)
+		}
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		rec := serve(t, mux, "POST", "/users/reward-http/redemptions", `{"rewardId":"http-sticker"}`, "Idempotency-Key", "redeem-once")
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status 201; got %d: %s", rec.Code, rec.Body.String())
+		}
+		if replay := serve(t, mux, "POST", "/users/reward-http/redemptions", `{"rewardId":"http-sticker"}`, "Idempotency-Key", "redeem-once"); replay.Code != http.StatusCreated || replay.Body.String() != rec.Body.String() {
+			t.Errorf("expected the retry to replay the redemption; got %d: %s", replay.Code, replay.Body.String())
+		}
+		if rec := serve(t, mux, "POST", "/users/reward-http/redemptions", `{"rewardId":"http-sticker"}`); rec.Code != http.StatusConflict {
+			t.Errorf("expected status 409 once out of stock; got %d", rec.Code)
+		}
+		if rec := serve(t, mux, "POST", "/users/reward-http/redemptions", `{"rewardId":"missing"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404; got %d", rec.Code)
+		}
+
+		list := &pb.RedemptionList{}
+		rec = serve(t, mux, "GET", "/users/reward-http/redemptions", "")
+		if err := protojson.Unmarshal(rec.Body.Bytes(), list); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if len(list.Redemptions) != 1 {
+			t.Errorf("expected one redemption, got %v", list.Redemptions)
+		}
+	})
+
+	t.Run("SameKeyOtherUser", func(t *testing.T) {
+		serve(t, mux, "POST", "/receipts/process", `{"userId":"reward-http-other","receipt":{"retailer":"Harbor Hardware","purchaseDate":"2022-08-02","purchaseTime":"10:00","items":[{"shortDescription":"Saw","price":"9.00"}],"total":"9.00"}}`)
+		serve(t, mux, "POST", "/admin/rewards", `{"id":"http-badge","name":"Badge","cost":1,"inventory":2}`)
+		first := serve(t, mux, "POST", "/users/reward-http/redemptions", `{"rewardId":"http-badge"}`, "Idempotency-Key", "shared-key")
+		second := serve(t, mux, "POST", "/users/reward-http-other/redemptions", `{"rewardId":"http-badge"}`, "Idempotency-Key", "shared-key")
+		if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+			t.Fatalf("expected both users to redeem; got %d and %d: %s", first.Code, second.Code, second.Body.String())
+		}
+		if second.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("expected the second user's redemption not to be a replay of the first")
+		}
+		redemption := &pb.Redemption{}
+		if err := protojson.Unmarshal(second.Body.Bytes(), redemption); err != nil || redemption.UserId != "reward-http-other" {
+			t.Errorf("expected a redemption for the second user, got %s", second.Body.String())
+		}
+	})
+
+	t.Run("InsufficientPoints", func(t *testing.T) {
+		serve(t, mux, "POST", "/admin/rewards", `{"id":"http-yacht","name":"Yacht","cost":100000,"inventory":1}`)
+		if rec := serve(t, mux, "POST", "/users/reward-http/redemptions", `{"rewardId":"http-yacht"}`); rec.Code != http.StatusConflict {
+			t.Errorf("expected status 409; got %d", rec.Code)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		if rec := serve(t, mux, "DELETE", "/admin/rewards/http-yacht", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("expected status 204; got %d", rec.Code)
+		}
+		if rec := serve(t, mux, "DELETE", "/admin/rewards/http-yacht", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404; got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// searchReceipts lists the stored receipts matching the query string, a page at a time.
+func searchReceipts(w http.ResponseWriter, r *http.Request) {
+	query, err := parseReceiptQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The search is invalid: %v.", err))
+		return
+	}
+	writeReceiptPage(w, query)
+}
+
+// writeReceiptPage runs a search and responds with the page of receipts it finds.
+func writeReceiptPage(w http.ResponseWriter, query receiptprocessor.ReceiptQuery) {
+	page, err := receiptprocessor.SearchReceipts(query)
+	if errors.Is(err, receiptprocessor.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, "The cursor is invalid.")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The search is invalid: %v.", err))
+		return
+	}
+
+	response, err := protojson.Marshal(page)
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// parseReceiptQuery reads the filters of GET /receipts. sort takes a field name, with a leading
+// "-" to sort in descending order.
+func parseReceiptQuery(values url.Values) (receiptprocessor.ReceiptQuery, error) {
+	query := receiptprocessor.ReceiptQuery{
+		Retailer:      values.Get("retailer"),
+		PurchasedFrom: values.Get("purchasedFrom"),
+		PurchasedTo:   values.Get("purchasedTo"),
+		Status:        values.Get("status"),
+		Cursor:        values.Get("cursor"),
+	}
+	for name, date := range map[string]string{"purchasedFrom": query.PurchasedFrom, "purchasedTo": query.PurchasedTo} {
+		if date != "" && !datePattern.MatchString(date) {
+			return query, fmt.Errorf("%s must be a YYYY-MM-DD date", name)
+		}
+	}
+
+	for name, target := range map[string]**receiptprocessor.Money{"minTotal": &query.MinTotal, "maxTotal": &query.MaxTotal} {
+		if value := values.Get(name); value != "" {
+			amount, err := receiptprocessor.ParseMoney(value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an amount like 12.34", name)
+			}
+			*target = &amount
+		}
+	}
+
+	for name, target := range map[string]**int{"minPoints": &query.MinPoints, "maxPoints": &query.MaxPoints} {
+		if value := values.Get(name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 0 {
+				return query, fmt.Errorf("%s must be a whole number", name)
+			}
+			*target = &number
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("limit must be a positive whole number")
+		}
+		query.Limit = limit
+	}
+
+	sort := values.Get("sort")
+	query.Descending = strings.HasPrefix(sort, "-")
+	query.SortBy = strings.TrimPrefix(sort, "-")
+	return query, nil
+}
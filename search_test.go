@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestSearchReceipts(t *testing.T) {
+	mux := buildRouter()
+	for _, receipt := range []string{
+		`{"retailer":"Quarry Hardware","purchaseDate":"2021-08-01","purchaseTime":"10:00","items":[{"shortDescription":"Nails","price":"4.00"}],"total":"4.00"}`,
+		`{"retailer":"Quarry Hardware","purchaseDate":"2021-08-02","purchaseTime":"10:00","items":[{"shortDescription":"Hammer","price":"19.99"}],"total":"19.99"}`,
+	} {
+		req, err := http.NewRequest("POST", "/receipts/process", strings.NewReader(receipt))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	search := func(t *testing.T, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("GET", "/receipts?"+query, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Filtered", func(t *testing.T) {
+		rec := search(t, "retailer=quarry+hardware&minTotal=10.00&sort=-total")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200; got %d", rec.Code)
+		}
+		page := &pb.ReceiptPage{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), page); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if len(page.Receipts) != 1 || page.Receipts[0].Receipt.Total != "19.99" {
+			t.Errorf("expected the 19.99 receipt, got %v", page.Receipts)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, query := range []string{"purchasedFrom=08/01/2021", "minTotal=ten", "limit=0", "sort=retailer", "cursor=bogus"} {
+			if rec := search(t, query); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400; got %d", query, rec.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+
+	"github.com/keith-decker/fetch-assignment/openapi"
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+)
+
+// apiSpec is the OpenAPI document. Request validation is driven by it, so a field or
+// pattern added to api.yml is enforced without any code changes.
+//
+//go:embed api.yml
+var apiSpec []byte
+
+func init() {
+	spec, err := openapi.Load(apiSpec)
+	if err != nil {
+		log.Fatalf("Error loading api.yml: %v", err)
+	}
+	schema, err := spec.RequestSchema("/receipts/process", "post")
+	if err != nil {
+		log.Fatalf("Error loading the receipt schema from api.yml: %v", err)
+	}
+	receiptprocessor.SetReceiptSchema(schema)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/keith-decker/fetch-assignment/receiptprocessor"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func getUserBalance(w http.ResponseWriter, r *http.Request) {
+	response, err := protojson.Marshal(receiptprocessor.GetUserBalance(r.PathValue("id")))
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// listUserReceipts lists a user's receipts, taking the same filters, sorting and cursor as GET /receipts.
+func listUserReceipts(w http.ResponseWriter, r *http.Request) {
+	query, err := parseReceiptQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The search is invalid: %v.", err))
+		return
+	}
+	query.UserID = r.PathValue("id")
+	writeReceiptPage(w, query)
+}
+
+// getUserTier returns a user's loyalty tier, with the points it is based on and its history.
+func getUserTier(w http.ResponseWriter, r *http.Request) {
+	response, err := protojson.Marshal(receiptprocessor.GetUserTier(r.PathValue("id")))
+	if err != nil {
+		http.Error(w, "An error occurred while processing the request.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// sweepTiers re-evaluates tiers every interval, for as long as the server runs, so users move
+// down as their points from more than a year ago stop counting.
+func sweepTiers(interval time.Duration) {
+	for at := range time.Tick(interval) {
+		receiptprocessor.RefreshTiers(at)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/keith-decker/fetch-assignment/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestUserEndpoints(t *testing.T) {
+	mux := buildRouter()
+	t.Run("Submit", func(t *testing.T) {
+		if rec := serve(t, mux, "POST", "/receipts/process", `{"retailer":"Meadow Farm","purchaseDate":"2022-07-01","purchaseTime":"09:00","items":[{"shortDescription":"Eggs","price":"4.00"}],"total":"4.00"}`, "X-User-Id", "user-http"); rec.Code != http.StatusAccepted {
+			t.Errorf("expected status 202 with the user in the header; got %d", rec.Code)
+		}
+		if rec := serve(t, mux, "POST", "/receipts/process", `{"userId":"user-http","receipt":{"retailer":"Meadow Farm","purchaseDate":"2022-07-02","purchaseTime":"09:00","items":[{"shortDescription":"Milk","price":"3.10"}],"total":"3.10"}}`); rec.Code != http.StatusAccepted {
+			t.Errorf("expected status 202 with the user in the body; got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := serve(t, mux, "POST", "/receipts/process", `{"userId":"someone-else","receipt":{"retailer":"Meadow Farm","purchaseDate":"2022-07-03","purchaseTime":"09:00","items":[{"shortDescription":"Jam","price":"5.00"}],"total":"5.00"}}`, "X-User-Id", "user-http"); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 when the header and body disagree; got %d", rec.Code)
+		}
+	})
+
+	t.Run("Balance", func(t *testing.T) {
+		rec := serve(t, mux, "GET", "/users/user-http/balance", "")
+		balance := &pb.UserBalance{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), balance); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if balance.Receipts != 2 || balance.Points == 0 {
+			t.Errorf("expected points from 2 receipts, got %v", balance)
+		}
+	})
+
+	t.Run("Receipts", func(t *testing.T) {
+		rec := serve(t, mux, "GET", "/users/user-http/receipts?sort=-purchaseDate", "")
+		page := &pb.ReceiptPage{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), page); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if len(page.Receipts) != 2 || page.Receipts[0].Receipt.PurchaseDate != "2022-07-02" {
+			t.Errorf("expected the user's receipts, newest purchase first, got %v", page.Receipts)
+		}
+	})
+
+	t.Run("Tier", func(t *testing.T) {
+		rec := serve(t, mux, "GET", "/users/user-http/tier", "")
+		tier := &pb.UserTier{}
+		if err := protojson.Unmarshal(rec.Body.Bytes(), tier); err != nil {
+			t.Fatalf("could not decode response %q: %v", rec.Body.String(), err)
+		}
+		if tier.Tier != "Bronze" || tier.Points == 0 || tier.NextTier != "Silver" {
+			t.Errorf("expected Bronze with points towards Silver, got %v", tier)
+		}
+	})
+}